@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/mclyashko/everlock/internal/share"
+)
+
+const maxShareInputSize = 64 << 10
+
+// утилита хранителя: создает ключ подписи Ed25519 и подписывает часть ключа перед вводом
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "keygen":
+		keygen(os.Args[2:])
+	case "sign":
+		sign(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  everlock-keyholder keygen -out signing-key.txt")
+	fmt.Fprintln(os.Stderr, "  everlock-keyholder sign -key signing-key.txt < share.txt")
+	os.Exit(2)
+}
+
+// создает ключ подписи, сохраняет закрытый ключ в файл и печатает открытый ключ для создателя сообщения
+func keygen(args []string) {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := flags.String("out", "", "file to write the private signing key to")
+	_ = flags.Parse(args)
+
+	if *out == "" {
+		usage()
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key, error: %v", err)
+	}
+
+	seed := base64.StdEncoding.EncodeToString(privateKey.Seed())
+	if err = os.WriteFile(*out, []byte(seed+"\n"), 0o600); err != nil {
+		log.Fatalf("Failed to write signing key, error: %v", err)
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(publicKey))
+}
+
+// подписывает часть ключа из стандартного ввода и печатает подпись для страницы расшифровки
+func sign(args []string) {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyFile := flags.String("key", "", "file with the private signing key")
+	_ = flags.Parse(args)
+
+	if *keyFile == "" {
+		usage()
+	}
+
+	encodedSeed, err := os.ReadFile(*keyFile)
+	if err != nil {
+		log.Fatalf("Failed to read signing key, error: %v", err)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSeed)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		log.Fatalf("Invalid signing key, error: %v", err)
+	}
+
+	input, err := io.ReadAll(io.LimitReader(os.Stdin, maxShareInputSize))
+	if err != nil {
+		log.Fatalf("Failed to read share, error: %v", err)
+	}
+
+	s, err := share.Decode(string(input))
+	if err != nil {
+		log.Fatalf("Failed to parse share, error: %v", err)
+	}
+	if s.MessageID == uuid.Nil || s.GroupIndex == share.NoGroup {
+		log.Fatalf("Share has no message id or group, sign the full key block")
+	}
+
+	signature := ed25519.Sign(ed25519.NewKeyFromSeed(seed), share.SigningPayload(s))
+	fmt.Println(base64.StdEncoding.EncodeToString(signature))
+}
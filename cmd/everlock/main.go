@@ -1,20 +1,215 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"sync/atomic"
 
+	"github.com/google/uuid"
+
+	"github.com/mclyashko/everlock/internal/blob"
 	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/crypt"
 	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/logging"
+	"github.com/mclyashko/everlock/internal/logic"
+	"github.com/mclyashko/everlock/internal/notify"
+	"github.com/mclyashko/everlock/internal/pow"
+	"github.com/mclyashko/everlock/internal/ratelimit"
+	"github.com/mclyashko/everlock/internal/template"
+	"github.com/mclyashko/everlock/internal/tlsconf"
+	"github.com/mclyashko/everlock/internal/tracing"
 	"github.com/mclyashko/everlock/internal/web"
 )
 
 func main() {
-	config := config.LoadConfig()
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "validate" {
+		os.Exit(validateConfig(args[2:]))
+	}
+	if len(args) >= 3 && args[0] == "message" && args[1] == "delete" {
+		os.Exit(deleteMessage(args[2], args[3:]))
+	}
+
+	config, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if err := logging.Setup(&config.Log); err != nil {
+		fatal("invalid log configuration", err)
+	}
+	slog.Info("Config successfully loaded")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), &config.Tracing)
+	if err != nil {
+		fatal("invalid tracing configuration", err)
+	}
+
 	pool := db.LoadDbPool(&config.Db)
 
-	web.ConfigureRouter(config, pool)
+	store, err := blob.NewStore(&config.Blob)
+	if err != nil {
+		fatal("unable to create blob store", err)
+	}
+
+	suite, err := crypt.ParseSuite(config.Crypt.Suite)
+	if err != nil {
+		fatal("invalid cipher suite", err)
+	}
+
+	// уведомления отправляются в фоне, чтобы медленный webhook не задерживал запросы
+	notifier := notify.NewNotifier(&config.Notify)
+	notifier.Start()
+
+	// фоновые задачи сервера работают до его остановки
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	var ready atomic.Bool
+
+	handler, err := web.ConfigureRouter(background, config, pool, store, suite, notifier, &ready)
+	if err != nil {
+		fatal("unable to configure router", err)
+	}
+
+	tlsConfig, reloader, err := tlsconf.NewConfig(&config.Web)
+	if err != nil {
+		fatal("invalid tls configuration", err)
+	}
+	if reloader != nil {
+		go reloader.Watch(background, config.Web.TLSReloadInterval)
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	servers := []*http.Server{web.NewServer(&config.Web, handler, tlsConfig)}
+
+	if metricsServer := web.NewMetricsServer(config); metricsServer != nil {
+		servers = append(servers, metricsServer)
+		slog.Info("serving metrics", "addr", config.Metrics.Addr)
+	} else if config.Metrics.Password == "" {
+		slog.Info("metrics are disabled: neither METRICS_ADDR nor METRICS_PASSWORD is set")
+	}
+
+	slog.Info("starting Everlock", "scheme", scheme, "port", config.Web.Port)
+	if err := web.ListenAndServe(&config.Web, &ready, servers...); err != nil {
+		pool.Close()
+		fatal("server failed", err)
+	}
+	stopBackground()
+
+	// оставшиеся уведомления и спаны отправляются в пределах того же таймаута, что и завершение запросов
+	ctx, cancel := context.WithTimeout(context.Background(), config.Web.ShutdownTimeout)
+	defer cancel()
+	if err := notifier.Close(ctx); err != nil {
+		slog.Error("failed to send pending notifications", "error", err)
+	}
+
+	pool.Close()
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+
+	slog.Info("Everlock stopped")
+}
+
+// выполняет команду "config validate": собирает конфигурацию так же, как при запуске, проверяет ее
+// пакетами, которые ее используют, и выводит все найденные проблемы. Возвращает код завершения
+func validateConfig(args []string) int {
+	c, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+
+	// проверки пакетов выполняются и при ошибках загрузки, чтобы сообщить обо всех проблемах сразу
+	errs := []error{
+		err,
+		logging.Validate(&c.Log),
+		tracing.Validate(&c.Tracing),
+	}
+	if _, err := crypt.ParseSuite(c.Crypt.Suite); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := tlsconf.NewConfig(&c.Web); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := pow.NewIssuer(&c.Abuse); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ratelimit.NewClientIP(&c.RateLimit); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := template.NewRegistry(&c.Templates); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		fmt.Fprintf(os.Stderr, "configuration is invalid:\n%v\n", err)
+		return 1
+	}
+
+	fmt.Println("configuration is valid")
+	return 0
+}
+
+// выполняет команду "message delete": удаляет сообщение с вложениями, записывая удаление в журнал сообщения.
+// Возвращает код завершения
+func deleteMessage(id string, args []string) int {
+	messageID, err := uuid.Parse(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid message id %q: %v\n", id, err)
+		return 1
+	}
+
+	c, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	if err := logging.Setup(&c.Log); err != nil {
+		fatal("invalid log configuration", err)
+	}
+
+	pool := db.LoadDbPool(&c.Db)
+	defer pool.Close()
+
+	store, err := blob.NewStore(&c.Blob)
+	if err != nil {
+		slog.Error("unable to create blob store", "error", err)
+		return 1
+	}
+
+	logic.SetAuditSecret(c.Audit.Secret)
+
+	if err = logic.DeleteMessage(context.Background(), pool, store, messageID); err != nil {
+		slog.Error("failed to delete message", "message_id", messageID, "error", err)
+		return 1
+	}
+
+	fmt.Printf("message %v deleted\n", messageID)
+	return 0
+}
 
-	log.Printf("Starting Everlock on http://localhost:%s\n", config.Web.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Web.Port, nil))
+// пишет ошибку в журнал и завершает процесс
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,40 @@
+package blob
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const (
+	// LocalBackend хранит объекты в локальной файловой системе
+	LocalBackend = "local"
+)
+
+// Writer объект, открытый на запись
+type Writer interface {
+	io.WriteCloser
+	// Abort отменяет запись и удаляет недописанные данные; объект не публикуется
+	Abort() error
+}
+
+// Store хранилище зашифрованных вложений
+type Store interface {
+	// Create открывает объект на запись; объект становится доступен только после успешного Close
+	Create(id string) (Writer, error)
+	// Open открывает объект на чтение
+	Open(id string) (io.ReadCloser, error)
+	// Delete удаляет объект, отсутствие объекта ошибкой не считается
+	Delete(id string) error
+}
+
+// NewStore создает хранилище вложений для заданного в конфигурации бэкенда
+func NewStore(c *config.Blob) (Store, error) {
+	switch c.Backend {
+	case LocalBackend:
+		return NewFileStore(c.Dir)
+	default:
+		return nil, fmt.Errorf("unknown blob backend: %s", c.Backend)
+	}
+}
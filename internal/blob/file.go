@@ -0,0 +1,130 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dirPerm  = 0o700
+	filePerm = 0o600
+)
+
+// FileStore хранит объекты файлами в одном каталоге
+type FileStore struct {
+	dir string
+}
+
+// fileWriter пишет объект во временный файл и переименовывает его при закрытии
+type fileWriter struct {
+	file *os.File
+	path string
+}
+
+// NewFileStore создает хранилище в каталоге dir, создавая каталог при необходимости
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create blob dir %s, error: %v", dir, err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Create(id string) (Writer, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(s.dir, "."+id+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob %s, error: %v", id, err)
+	}
+
+	if err = file.Chmod(filePerm); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to chmod blob %s, error: %v", id, err)
+	}
+
+	return &fileWriter{file: file, path: path}, nil
+}
+
+func (s *FileStore) Open(id string) (io.ReadCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s, error: %v", id, err)
+	}
+
+	return file, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob %s, error: %v", id, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid blob id: %q", id)
+	}
+
+	return filepath.Join(s.dir, id), nil
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Close сбрасывает данные на диск и атомарно публикует объект
+func (w *fileWriter) Close() error {
+	tmpPath := w.file.Name()
+
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync blob, error: %v", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to close blob, error: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to publish blob, error: %v", err)
+	}
+
+	return nil
+}
+
+// Abort закрывает и удаляет временный файл, не публикуя объект
+func (w *fileWriter) Abort() error {
+	tmpPath := w.file.Name()
+	_ = w.file.Close()
+
+	if err := os.Remove(tmpPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove blob, error: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package card
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"rsc.io/qr"
+)
+
+const (
+	// размеры страницы A4 в пунктах, общие для SVG и PDF
+	pageWidth  = 595
+	pageHeight = 842
+
+	margin          = 48
+	titleFontSize   = 20
+	headerFontSize  = 11
+	shareFontSize   = 9
+	noteFontSize    = 9
+	lineSpacing     = 1.35
+	qrSize          = 300
+	qrQuietZone     = 4
+	maxShareLineLen = 80
+)
+
+// Card печатная карточка части ключа для бумажной резервной копии
+type Card struct {
+	MessageID uuid.UUID
+	// Label описывает хранителя, например, "Keyholder 2 of 3" или получателя зашифрованной части
+	Label string
+	// Group название группы хранителей, пустое для сообщений с одной группой
+	Group string
+	// Share текст части ключа, напечатанный под QR-кодом
+	Share string
+	// QRPayload содержимое QR-кода, которое вводится на странице расшифровки после сканирования
+	QRPayload string
+	// Encrypted означает, что часть ключа зашифрована открытым ключом хранителя
+	Encrypted bool
+	// Signed означает, что при вводе часть ключа должна быть подписана ключом Ed25519 хранителя
+	Signed bool
+}
+
+// строка текста на странице; y - базовая линия, отсчитываемая от верхнего края
+type textLine struct {
+	x, y      float64
+	size      float64
+	monospace bool
+	text      string
+}
+
+// горизонтальный отрезок черных модулей QR-кода
+type qrRun struct {
+	x, y, width, height float64
+}
+
+// page разметка карточки, которую одинаково рисуют SVG и PDF
+type page struct {
+	lines []textLine
+	qr    []qrRun
+}
+
+func layout(c *Card) (*page, error) {
+	code, err := qr.Encode(c.QRPayload, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code, error: %v", err)
+	}
+
+	p := &page{}
+	y := float64(margin + titleFontSize)
+	p.addLine(margin, &y, titleFontSize, false, "Everlock Key Card")
+	y += headerFontSize
+	p.addLine(margin, &y, headerFontSize, false, "Message ID: "+c.MessageID.String())
+	p.addLine(margin, &y, headerFontSize, false, "Keyholder: "+c.Label)
+	if c.Group != "" {
+		p.addLine(margin, &y, headerFontSize, false, "Group: "+c.Group)
+	}
+
+	modules := code.Size + 2*qrQuietZone
+	moduleSize := float64(qrSize) / float64(modules)
+	qrLeft := float64(pageWidth-qrSize) / 2
+	qrTop := y
+	for row := 0; row < code.Size; row++ {
+		for col := 0; col < code.Size; {
+			if !code.Black(col, row) {
+				col++
+				continue
+			}
+			start := col
+			for col < code.Size && code.Black(col, row) {
+				col++
+			}
+			p.qr = append(p.qr, qrRun{
+				x:      qrLeft + float64(start+qrQuietZone)*moduleSize,
+				y:      qrTop + float64(row+qrQuietZone)*moduleSize,
+				width:  float64(col-start) * moduleSize,
+				height: moduleSize,
+			})
+		}
+	}
+
+	y = qrTop + qrSize + shareFontSize*2
+	for _, line := range wrap(c.Share, maxShareLineLen) {
+		p.addLine(margin, &y, shareFontSize, true, line)
+	}
+
+	y += noteFontSize
+	for _, line := range instructions(c) {
+		p.addLine(margin, &y, noteFontSize, false, line)
+	}
+
+	return p, nil
+}
+
+func (p *page) addLine(x float64, y *float64, size float64, monospace bool, text string) {
+	p.lines = append(p.lines, textLine{x: x, y: *y, size: size, monospace: monospace, text: text})
+	*y += size * lineSpacing
+}
+
+func instructions(c *Card) []string {
+	decryptPath := "/decrypt/" + c.MessageID.String()
+	var lines []string
+	if c.Encrypted {
+		lines = []string{
+			"Recovery:",
+			"1. Scan the QR code or type the text above and decrypt it with your private key",
+			"   (age -d -i your-identity.txt or gpg --decrypt).",
+			"2. Open " + decryptPath + " on the Everlock server and enter the decrypted key.",
+			"Keep this card and your private key in separate places.",
+		}
+	} else {
+		lines = []string{
+			"Recovery:",
+			"1. Open " + decryptPath + " on the Everlock server.",
+			"2. Scan the QR code and enter its text, or type the key above, into the key field.",
+			"Keep this card private: anyone holding enough keys can read the message.",
+		}
+	}
+	if c.Signed {
+		lines = append(lines,
+			"This key must be signed: run everlock-keyholder sign -key your-signing-key.txt with the key",
+			"on standard input and enter the printed signature together with the key.")
+	}
+	return lines
+}
+
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		for len(line) > width {
+			lines = append(lines, line[:width])
+			line = line[width:]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
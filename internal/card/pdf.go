@@ -0,0 +1,75 @@
+package card
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PDF рисует карточку как одностраничный PDF-документ размера A4.
+// Используются стандартные шрифты Helvetica и Courier, поэтому символы вне ASCII заменяются на "?"
+func PDF(c *Card) ([]byte, error) {
+	p, err := layout(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	content.WriteString("0 g\n")
+	for _, r := range p.qr {
+		fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re\n", r.x, pageHeight-r.y-r.height, r.width, r.height)
+	}
+	content.WriteString("f\n")
+
+	for _, l := range p.lines {
+		font := "F1"
+		if l.monospace {
+			font = "F2"
+		}
+		fmt.Fprintf(&content, "BT /%s %.0f Tf %.2f %.2f Td (%s) Tj ET\n", font, l.size, l.x, pageHeight-l.y, pdfString(l.text))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> /Contents 4 0 R >>", pageWidth, pageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return b.Bytes(), nil
+}
+
+// экранирует строку PDF и заменяет символы, которых нет в стандартных шрифтах
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
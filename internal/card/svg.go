@@ -0,0 +1,42 @@
+package card
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+)
+
+// SVG рисует карточку как SVG-документ размера A4
+func SVG(c *Card) ([]byte, error) {
+	p, err := layout(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="210mm" height="297mm" viewBox="0 0 %d %d">`, pageWidth, pageHeight)
+	b.WriteString("\n")
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`, pageWidth, pageHeight)
+	b.WriteString("\n")
+
+	b.WriteString(`<path fill="#000" d="`)
+	for _, r := range p.qr {
+		fmt.Fprintf(&b, "M%.2f %.2fh%.2fv%.2fh-%.2fz", r.x, r.y, r.width, r.height, r.width)
+	}
+	b.WriteString(`"/>`)
+	b.WriteString("\n")
+
+	for _, l := range p.lines {
+		family := "Helvetica, Arial, sans-serif"
+		if l.monospace {
+			family = "Courier, monospace"
+		}
+		fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" font-family="%s" font-size="%.0f" xml:space="preserve">%s</text>`,
+			l.x, l.y, family, l.size, html.EscapeString(l.text))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("</svg>\n")
+
+	return b.Bytes(), nil
+}
@@ -1,13 +1,8 @@
 package config
 
 import (
-	"log"
-	"os"
-	"path/filepath"
-	"strconv"
+	"errors"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
 const (
@@ -26,7 +21,100 @@ const (
 	dbMaxConnIdleTimeKey   = "DB_MAXCONNIDLETIME"
 	dbHealthCheckPeriodKey = "DB_HEALTHCHECKPERIOD"
 	dbConnectTimeoutKey    = "DB_CONNECTTIMEOUT"
+	dbStatementTimeoutKey  = "DB_STATEMENT_TIMEOUT"
+	dbQueryTimeoutKey      = "DB_QUERY_TIMEOUT"
 	appPortKey             = "APP_PORT"
+	notifyWebhookURLKey    = "NOTIFY_WEBHOOK_URL"
+	notifyTimeoutKey       = "NOTIFY_TIMEOUT"
+	blobBackendKey         = "BLOB_BACKEND"
+	blobDirKey             = "BLOB_DIR"
+	cryptSuiteKey          = "CRYPT_SUITE"
+	rateIPPerMinuteKey     = "RATE_LIMIT_IP_PER_MINUTE"
+	rateIPBurstKey         = "RATE_LIMIT_IP_BURST"
+	rateMsgPerMinuteKey    = "RATE_LIMIT_MESSAGE_PER_MINUTE"
+	rateMsgBurstKey        = "RATE_LIMIT_MESSAGE_BURST"
+	trustedProxiesKey      = "TRUSTED_PROXIES"
+	lockoutFailuresKey     = "LOCKOUT_FAILURES"
+	lockoutDurationKey     = "LOCKOUT_DURATION"
+	createPerHourKey       = "CREATE_LIMIT_PER_HOUR"
+	createBurstKey         = "CREATE_LIMIT_BURST"
+	powDifficultyKey       = "POW_DIFFICULTY"
+	powSecretKey           = "POW_SECRET"
+	powTTLKey              = "POW_TTL"
+	maxStoredMessagesKey   = "MAX_STORED_MESSAGES"
+	trustedOriginsKey      = "CSRF_TRUSTED_ORIGINS"
+	secureCookiesKey       = "COOKIE_SECURE"
+	cspKey                 = "CONTENT_SECURITY_POLICY"
+	frameOptionsKey        = "FRAME_OPTIONS"
+	referrerPolicyKey      = "REFERRER_POLICY"
+	hstsMaxAgeKey          = "HSTS_MAX_AGE"
+	hstsSubdomainsKey      = "HSTS_INCLUDE_SUBDOMAINS"
+	tlsCertFileKey         = "TLS_CERT_FILE"
+	tlsKeyFileKey          = "TLS_KEY_FILE"
+	tlsMinVersionKey       = "TLS_MIN_VERSION"
+	tlsClientCAFileKey     = "TLS_CLIENT_CA_FILE"
+	tlsReloadIntervalKey   = "TLS_RELOAD_INTERVAL"
+	adminPathsKey          = "ADMIN_PATHS"
+	readTimeoutKey         = "HTTP_READ_TIMEOUT"
+	readHeaderTimeoutKey   = "HTTP_READ_HEADER_TIMEOUT"
+	writeTimeoutKey        = "HTTP_WRITE_TIMEOUT"
+	idleTimeoutKey         = "HTTP_IDLE_TIMEOUT"
+	maxHeaderBytesKey      = "HTTP_MAX_HEADER_BYTES"
+	shutdownTimeoutKey     = "HTTP_SHUTDOWN_TIMEOUT"
+	shutdownDelayKey       = "HTTP_SHUTDOWN_DELAY"
+	metricsAddrKey         = "METRICS_ADDR"
+	metricsUsernameKey     = "METRICS_USERNAME"
+	metricsPasswordKey     = "METRICS_PASSWORD"
+	logLevelKey            = "LOG_LEVEL"
+	tracingExporterKey     = "TRACING_EXPORTER"
+	tracingEndpointKey     = "TRACING_OTLP_ENDPOINT"
+	tracingSampleRatioKey  = "TRACING_SAMPLE_RATIO"
+	tracingServiceNameKey  = "TRACING_SERVICE_NAME"
+	templateDirKey         = "TEMPLATE_DIR"
+	staticDirKey           = "STATIC_DIR"
+	templateReloadKey      = "TEMPLATE_RELOAD"
+	auditSecretKey         = "AUDIT_SECRET"
+	auditUsernameKey       = "AUDIT_USERNAME"
+	auditPasswordKey       = "AUDIT_PASSWORD"
+
+	defaultNotifyTimeout    = 10 * time.Second
+	defaultStatementTimeout = 5 * time.Second
+	defaultQueryTimeout     = 10 * time.Second
+	defaultBlobBackend      = "local"
+	defaultBlobDir          = "/var/lib/everlock/blobs"
+	defaultCryptSuite       = "aes-256-gcm"
+
+	defaultRateIPPerMinute  = 30
+	defaultRateIPBurst      = 10
+	defaultRateMsgPerMinute = 20
+	defaultRateMsgBurst     = 10
+	defaultTrustedProxies   = "127.0.0.1/32,::1/128"
+	defaultLockoutFailures  = 3
+	defaultLockoutDuration  = time.Hour
+	defaultCreatePerHour    = 10
+	defaultCreateBurst      = 5
+	defaultPowTTL           = 10 * time.Minute
+	defaultCSP              = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self' data:; " +
+		"form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+	defaultFrameOptions   = "DENY"
+	defaultReferrerPolicy = "no-referrer"
+	defaultHSTSMaxAge     = 180 * 24 * time.Hour
+	defaultTLSMinVersion  = "1.2"
+	defaultTLSReload      = time.Minute
+	defaultAdminPaths     = "/audit/,/metrics"
+
+	defaultReadTimeout       = time.Minute
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultMaxHeaderBytes    = 64 << 10
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultShutdownDelay     = 5 * time.Second
+	defaultMetricsUsername   = "metrics"
+	defaultAuditUsername     = "audit"
+	defaultLogLevel          = "info"
+	defaultTracingExporter   = "none"
+	defaultTracingService    = "everlock"
 )
 
 type Db struct {
@@ -41,78 +129,256 @@ type Db struct {
 	MaxConnIdleTime   time.Duration
 	HealthCheckPeriod time.Duration
 	ConnectTimeout    time.Duration
+	// StatementTimeout ограничивает выполнение одного SQL-запроса на стороне PostgreSQL (statement_timeout)
+	StatementTimeout time.Duration
+	// QueryTimeout ограничивает одно обращение к БД вместе с ожиданием свободного подключения в пуле
+	QueryTimeout time.Duration
 }
 
 type Web struct {
 	Port string
+	// TrustedOrigins источники (схема://хост), кроме самого сервера, которым разрешены изменяющие запросы
+	TrustedOrigins []string
+	// SecureCookies выставляет cookie флаг Secure, когда TLS завершается на прокси
+	SecureCookies bool
+	// заголовки безопасности; пустое значение отключает заголовок
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+	// HSTSMaxAge срок HSTS, отправляется только по TLS или при SecureCookies; 0 отключает HSTS
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// TLSCertFile и TLSKeyFile включают TLS на самом сервере; файлы перечитываются по SIGHUP и при изменении
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSMinVersion минимальная версия TLS: 1.2 или 1.3
+	TLSMinVersion     string
+	TLSReloadInterval time.Duration
+	// TLSClientCAFile включает проверку клиентских сертификатов для путей AdminPaths
+	TLSClientCAFile string
+	AdminPaths      []string
+	// таймауты HTTP сервера; ReadTimeout и WriteTimeout должны покрывать загрузку и выдачу вложений
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// ShutdownDelay время после SIGTERM, в течение которого /readyz сообщает о неготовности,
+	// а сервер еще принимает запросы, чтобы балансировщик успел убрать реплику
+	ShutdownDelay time.Duration
+	// ShutdownTimeout время на завершение начатых запросов после ShutdownDelay
+	ShutdownTimeout time.Duration
 }
 
-type App struct {
-	Db  Db
-	Web Web
+type Notify struct {
+	WebhookURL string
+	Timeout    time.Duration
+}
+
+type Blob struct {
+	Backend string
+	Dir     string
 }
 
-// LoadConfig загружает конфигурацию из .env файла
-func LoadConfig() *App {
-	env := os.Getenv(appEnvKey)
+type Crypt struct {
+	Suite string
+}
 
-	envFile := filepath.Join("..", "..", "internal", "config", ".env.dev")
-	if env == ProdEnvironment {
-		envFile = filepath.Join("..", "..", "internal", "config", ".env.prod")
-	}
+// RateLimit ограничения частоты ввода частей ключа и попыток расшифровки
+type RateLimit struct {
+	IPPerMinute      float64
+	IPBurst          int
+	MessagePerMinute float64
+	MessageBurst     int
+	// TrustedProxies подсети прокси, чьему заголовку X-Real-IP можно доверять
+	TrustedProxies []string
+	// LockoutFailures число неудачных восстановлений ключа, после которого клиенты, которые ввели части ключа,
+	// блокируются для сообщения
+	LockoutFailures int
+	LockoutDuration time.Duration
+}
 
-	if err := godotenv.Load(envFile); err != nil {
-		log.Fatalf("Error loading env file (%s), error: %v", envFile, err)
-	}
+// Abuse ограничения создания сообщений
+type Abuse struct {
+	CreatePerHour float64
+	CreateBurst   int
+	// PowDifficulty число нулевых бит доказательства работы, 0 отключает проверку
+	PowDifficulty int
+	// PowSecret ключ HMAC задач доказательства работы, общий для всех реплик
+	PowSecret string
+	PowTTL    time.Duration
+	// MaxStoredMessages предельное число хранимых сообщений, 0 снимает ограничение
+	MaxStoredMessages int64
+}
+
+// Metrics доступ к метрикам Prometheus
+type Metrics struct {
+	// Addr отдельный адрес для /metrics; если не задан, /metrics отдается основным сервером,
+	// но только при заданном пароле
+	Addr     string
+	Username string
+	// Password включает HTTP Basic аутентификацию
+	Password string
+}
+
+// Log настройки журнала
+type Log struct {
+	// Level минимальный уровень записей: debug, info, warn или error
+	Level string
+}
+
+// Tracing настройки трассировки OpenTelemetry
+type Tracing struct {
+	// Exporter none, otlp или stdout
+	Exporter string
+	// Endpoint адрес OTLP/HTTP коллектора, например, http://otel-collector:4318; если не задан,
+	// используются стандартные переменные OTEL_EXPORTER_OTLP_*
+	Endpoint    string
+	SampleRatio float64
+	ServiceName string
+}
+
+// Templates настройки шаблонов страниц и статических файлов; встроенные в программу файлы можно заменить своими
+type Templates struct {
+	// Dir каталог с шаблонами, которые используются вместо встроенных с тем же именем, например, для своего оформления
+	Dir string
+	// StaticDir каталог со статическими файлами, которые используются вместо встроенных с тем же именем
+	StaticDir string
+	// Reload перечитывает измененные шаблоны из Dir без перезапуска, для разработки; требует Dir
+	Reload bool
+}
+
+// Audit настройки журнала событий сообщений
+type Audit struct {
+	// Secret ключ HMAC цепочки журнала, общий для всех реплик; при его смене старые записи не проходят проверку
+	Secret   string
+	Username string
+	// Password включает выгрузку журнала с HTTP Basic аутентификацией; без него журнал выгружается,
+	// только если путь /audit/ защищен клиентским сертификатом
+	Password string
+}
+
+type App struct {
+	Db        Db
+	Web       Web
+	Notify    Notify
+	Blob      Blob
+	Crypt     Crypt
+	RateLimit RateLimit
+	Abuse     Abuse
+	Metrics   Metrics
+	Log       Log
+	Tracing   Tracing
+	Templates Templates
+	Audit     Audit
+}
+
+// Load собирает конфигурацию из флагов args, переменных окружения, файла конфигурации CONFIG_FILE,
+// .env файла окружения и значений по умолчанию, в порядке убывания приоритета.
+// Возвращает все найденные ошибки сразу, а не первую; конфигурация возвращается и вместе с ошибкой,
+// чтобы ее можно было проверить дальше
+func Load(args []string) (*App, error) {
+	l := newLoader(args)
+	env := l.get(appEnvKey, DevEnvironment)
 
 	config := &App{
 		Db: Db{
-			User:              mustGetEnv(dbUserKey),
-			Password:          mustGetEnv(dbPasswordKey),
-			Host:              mustGetEnv(dbHostKey),
-			Port:              mustGetEnv(dbPortKey),
-			Name:              mustGetEnv(dbNameKey),
-			MaxConns:          int32(mustGetEnvInt(dbMaxConnsKey)),
-			MinConns:          int32(mustGetEnvInt(dbMinConnsKey)),
-			MaxConnLifetime:   mustGetEnvDuration(dbMaxConnLifetimeKey),
-			MaxConnIdleTime:   mustGetEnvDuration(dbMaxConnIdleTimeKey),
-			HealthCheckPeriod: mustGetEnvDuration(dbHealthCheckPeriodKey),
-			ConnectTimeout:    mustGetEnvDuration(dbConnectTimeoutKey),
+			User:              l.mustGet(dbUserKey),
+			Password:          l.mustGet(dbPasswordKey),
+			Host:              l.mustGet(dbHostKey),
+			Port:              l.mustGet(dbPortKey),
+			Name:              l.mustGet(dbNameKey),
+			MaxConns:          int32(l.mustGetInt(dbMaxConnsKey)),
+			MinConns:          int32(l.mustGetInt(dbMinConnsKey)),
+			MaxConnLifetime:   l.mustGetDuration(dbMaxConnLifetimeKey),
+			MaxConnIdleTime:   l.mustGetDuration(dbMaxConnIdleTimeKey),
+			HealthCheckPeriod: l.mustGetDuration(dbHealthCheckPeriodKey),
+			ConnectTimeout:    l.mustGetDuration(dbConnectTimeoutKey),
+			StatementTimeout:  l.getDuration(dbStatementTimeoutKey, defaultStatementTimeout),
+			QueryTimeout:      l.getDuration(dbQueryTimeoutKey, defaultQueryTimeout),
 		},
 		Web: Web{
-			Port: mustGetEnv(appPortKey),
-		},
-	}
+			Port:           l.mustGet(appPortKey),
+			TrustedOrigins: l.getList(trustedOriginsKey, ""),
+			SecureCookies:  l.getBool(secureCookiesKey, false),
 
-	log.Println("Config successfully loaded")
-	return config
-}
+			ContentSecurityPolicy: l.get(cspKey, defaultCSP),
+			FrameOptions:          l.get(frameOptionsKey, defaultFrameOptions),
+			ReferrerPolicy:        l.get(referrerPolicyKey, defaultReferrerPolicy),
+			HSTSMaxAge:            l.getDuration(hstsMaxAgeKey, defaultHSTSMaxAge),
+			HSTSIncludeSubdomains: l.getBool(hstsSubdomainsKey, false),
 
-// mustGetEnv получает строковое значение или падает
-func mustGetEnv(key string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		log.Fatalf("Fatal: missing required env variable %s", key)
-	}
-	return value
-}
+			TLSCertFile:       l.get(tlsCertFileKey, ""),
+			TLSKeyFile:        l.get(tlsKeyFileKey, ""),
+			TLSMinVersion:     l.get(tlsMinVersionKey, defaultTLSMinVersion),
+			TLSReloadInterval: l.getDuration(tlsReloadIntervalKey, defaultTLSReload),
+			TLSClientCAFile:   l.get(tlsClientCAFileKey, ""),
+			AdminPaths:        l.getList(adminPathsKey, defaultAdminPaths),
 
-// mustGetEnvInt получает int из ENV или падает
-func mustGetEnvInt(key string) int {
-	valStr := mustGetEnv(key)
-	val, err := strconv.Atoi(valStr)
-	if err != nil {
-		log.Fatalf("Fatal: invalid int value for %s: %s, error: %v", key, valStr, err)
+			ReadTimeout:       l.getDuration(readTimeoutKey, defaultReadTimeout),
+			ReadHeaderTimeout: l.getDuration(readHeaderTimeoutKey, defaultReadHeaderTimeout),
+			WriteTimeout:      l.getDuration(writeTimeoutKey, defaultWriteTimeout),
+			IdleTimeout:       l.getDuration(idleTimeoutKey, defaultIdleTimeout),
+			MaxHeaderBytes:    l.getInt(maxHeaderBytesKey, defaultMaxHeaderBytes),
+			ShutdownDelay:     l.getDuration(shutdownDelayKey, defaultShutdownDelay),
+			ShutdownTimeout:   l.getDuration(shutdownTimeoutKey, defaultShutdownTimeout),
+		},
+		Notify: Notify{
+			WebhookURL: l.get(notifyWebhookURLKey, ""),
+			Timeout:    l.getDuration(notifyTimeoutKey, defaultNotifyTimeout),
+		},
+		Blob: Blob{
+			Backend: l.get(blobBackendKey, defaultBlobBackend),
+			Dir:     l.get(blobDirKey, defaultBlobDir),
+		},
+		Crypt: Crypt{
+			Suite: l.get(cryptSuiteKey, defaultCryptSuite),
+		},
+		RateLimit: RateLimit{
+			IPPerMinute:      l.getFloat(rateIPPerMinuteKey, defaultRateIPPerMinute),
+			IPBurst:          l.getInt(rateIPBurstKey, defaultRateIPBurst),
+			MessagePerMinute: l.getFloat(rateMsgPerMinuteKey, defaultRateMsgPerMinute),
+			MessageBurst:     l.getInt(rateMsgBurstKey, defaultRateMsgBurst),
+			TrustedProxies:   l.getList(trustedProxiesKey, defaultTrustedProxies),
+			LockoutFailures:  l.getInt(lockoutFailuresKey, defaultLockoutFailures),
+			LockoutDuration:  l.getDuration(lockoutDurationKey, defaultLockoutDuration),
+		},
+		Abuse: Abuse{
+			CreatePerHour:     l.getFloat(createPerHourKey, defaultCreatePerHour),
+			CreateBurst:       l.getInt(createBurstKey, defaultCreateBurst),
+			PowDifficulty:     l.getInt(powDifficultyKey, 0),
+			PowSecret:         l.get(powSecretKey, ""),
+			PowTTL:            l.getDuration(powTTLKey, defaultPowTTL),
+			MaxStoredMessages: int64(l.getInt(maxStoredMessagesKey, 0)),
+		},
+		Metrics: Metrics{
+			Addr:     l.get(metricsAddrKey, ""),
+			Username: l.get(metricsUsernameKey, defaultMetricsUsername),
+			Password: l.get(metricsPasswordKey, ""),
+		},
+		Log: Log{
+			Level: l.get(logLevelKey, defaultLogLevel),
+		},
+		Tracing: Tracing{
+			Exporter:    l.get(tracingExporterKey, defaultTracingExporter),
+			Endpoint:    l.get(tracingEndpointKey, ""),
+			SampleRatio: l.getFloat(tracingSampleRatioKey, 1),
+			ServiceName: l.get(tracingServiceNameKey, defaultTracingService),
+		},
+		Templates: Templates{
+			Dir:       l.get(templateDirKey, ""),
+			StaticDir: l.get(staticDirKey, ""),
+		},
+		Audit: Audit{
+			Secret:   l.mustGet(auditSecretKey),
+			Username: l.get(auditUsernameKey, defaultAuditUsername),
+			Password: l.get(auditPasswordKey, ""),
+		},
 	}
-	return val
-}
 
-// mustGetEnvDuration получает time.Duration из ENV или падает
-func mustGetEnvDuration(key string) time.Duration {
-	valStr := mustGetEnv(key)
-	val, err := time.ParseDuration(valStr)
-	if err != nil {
-		log.Fatalf("Fatal: invalid duration for %s: %s, error: %v", key, valStr, err)
-	}
-	return val
+	// перечитывать без каталога замены нечего, поэтому по умолчанию шаблоны перечитываются, только если он задан
+	config.Templates.Reload = l.getBool(templateReloadKey, env != ProdEnvironment && config.Templates.Dir != "")
+
+	l.errs = append(l.errs, config.validate()...)
+	return config, errors.Join(l.errs...)
 }
@@ -0,0 +1,398 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileKey = "CONFIG_FILE"
+	envFileKey    = "ENV_FILE"
+	// fileSuffix вариант настройки-секрета, значение которой читается из файла, например, DB_PASSWORD_FILE
+	fileSuffix = "_FILE"
+
+	flagsSource   = "flags"
+	envSource     = "environment"
+	defaultSource = "default"
+)
+
+// settingKeys все известные настройки; в файле конфигурации и во флагах допускаются только они
+var settingKeys = []string{
+	appEnvKey, configFileKey, envFileKey,
+	dbUserKey, dbPasswordKey, dbHostKey, dbPortKey, dbNameKey, dbMaxConnsKey, dbMinConnsKey,
+	dbMaxConnLifetimeKey, dbMaxConnIdleTimeKey, dbHealthCheckPeriodKey, dbConnectTimeoutKey,
+	dbStatementTimeoutKey, dbQueryTimeoutKey,
+	appPortKey, notifyWebhookURLKey, notifyTimeoutKey, blobBackendKey, blobDirKey, cryptSuiteKey,
+	rateIPPerMinuteKey, rateIPBurstKey, rateMsgPerMinuteKey, rateMsgBurstKey, trustedProxiesKey,
+	lockoutFailuresKey, lockoutDurationKey, createPerHourKey, createBurstKey,
+	powDifficultyKey, powSecretKey, powTTLKey, maxStoredMessagesKey,
+	trustedOriginsKey, secureCookiesKey, cspKey, frameOptionsKey, referrerPolicyKey, hstsMaxAgeKey, hstsSubdomainsKey,
+	tlsCertFileKey, tlsKeyFileKey, tlsMinVersionKey, tlsClientCAFileKey, tlsReloadIntervalKey, adminPathsKey,
+	readTimeoutKey, readHeaderTimeoutKey, writeTimeoutKey, idleTimeoutKey, maxHeaderBytesKey,
+	shutdownTimeoutKey, shutdownDelayKey,
+	metricsAddrKey, metricsUsernameKey, metricsPasswordKey, logLevelKey,
+	tracingExporterKey, tracingEndpointKey, tracingSampleRatioKey, tracingServiceNameKey,
+	templateDirKey, staticDirKey, templateReloadKey,
+	auditSecretKey, auditUsernameKey, auditPasswordKey,
+}
+
+// secretKeys настройки, которые можно задать файлом через вариант с суффиксом _FILE,
+// чтобы секрет не попадал в переменные окружения и аргументы процесса
+var secretKeys = []string{dbPasswordKey, powSecretKey, metricsPasswordKey, auditSecretKey, auditPasswordKey}
+
+// pathKeys настройки-пути, относительные значения которых в .env файле отсчитываются от каталога этого файла,
+// а не от рабочего каталога процесса
+var pathKeys = []string{
+	blobDirKey, templateDirKey, staticDirKey, tlsCertFileKey, tlsKeyFileKey, tlsClientCAFileKey,
+	dbPasswordKey + fileSuffix, powSecretKey + fileSuffix, metricsPasswordKey + fileSuffix,
+	auditSecretKey + fileSuffix, auditPasswordKey + fileSuffix,
+}
+
+// один источник настроек
+type layer struct {
+	name   string
+	values map[string]string
+}
+
+// loader ищет настройки в источниках по убыванию приоритета и накапливает ошибки,
+// чтобы сообщить обо всех проблемах конфигурации сразу
+type loader struct {
+	layers []layer
+	errs   []error
+	// unreadable секреты, файл _FILE которых не прочитан; об ошибке сообщается один раз
+	unreadable map[string]bool
+}
+
+func (l *loader) errorf(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+// lookup возвращает значение настройки и имя источника. Для секретов вариант _FILE того же источника
+// имеет приоритет над значением, а источник с более высоким приоритетом - над обоими
+func (l *loader) lookup(key string) (string, string, bool) {
+	for _, layer := range l.layers {
+		if isSecret(key) {
+			if path, ok := layer.values[key+fileSuffix]; ok {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					if !l.unreadable[key] {
+						l.unreadable[key] = true
+						l.errorf("failed to read %s%s (%s) from %s, error: %v", key, fileSuffix, layer.name, path, err)
+					}
+					return "", layer.name, false
+				}
+				return strings.TrimRight(string(data), "\r\n"), layer.name, true
+			}
+		}
+		if value, ok := layer.values[key]; ok {
+			return value, layer.name, true
+		}
+	}
+	return "", defaultSource, false
+}
+
+// mustGet получает строковое значение или отмечает отсутствие обязательной настройки
+func (l *loader) mustGet(key string) string {
+	value, _, exists := l.lookup(key)
+	if !exists {
+		l.missing(key)
+	}
+	return value
+}
+
+// missing отмечает отсутствие обязательной настройки. Если не прочитан файл секрета, об этом уже сообщено
+func (l *loader) missing(key string) {
+	if l.unreadable[key] {
+		return
+	}
+	l.errorf("missing required setting %s (flag -%s, env %s or config file key %s)", key, flagName(key), key, strings.ToLower(key))
+}
+
+// get получает строковое значение или значение по умолчанию
+func (l *loader) get(key string, defaultValue string) string {
+	value, _, exists := l.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	return value
+}
+
+// mustGetInt получает обязательное целое значение
+func (l *loader) mustGetInt(key string) int {
+	valStr, source, exists := l.lookup(key)
+	if !exists {
+		l.missing(key)
+		return 0
+	}
+	return l.parseInt(key, source, valStr, 0)
+}
+
+// getInt получает целое значение или значение по умолчанию. При ошибке разбора тоже возвращается значение
+// по умолчанию, чтобы проверка диапазонов не сообщала о той же настройке повторно
+func (l *loader) getInt(key string, defaultValue int) int {
+	valStr, source, exists := l.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	return l.parseInt(key, source, valStr, defaultValue)
+}
+
+func (l *loader) parseInt(key, source, valStr string, defaultValue int) int {
+	val, err := strconv.Atoi(valStr)
+	if err != nil {
+		l.errorf("invalid int value for %s (%s): %s, error: %v", key, source, valStr, err)
+		return defaultValue
+	}
+	return val
+}
+
+// mustGetDuration получает обязательное значение time.Duration
+func (l *loader) mustGetDuration(key string) time.Duration {
+	valStr, source, exists := l.lookup(key)
+	if !exists {
+		l.missing(key)
+		return 0
+	}
+	return l.parseDuration(key, source, valStr, 0)
+}
+
+// getDuration получает time.Duration или значение по умолчанию
+func (l *loader) getDuration(key string, defaultValue time.Duration) time.Duration {
+	valStr, source, exists := l.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	return l.parseDuration(key, source, valStr, defaultValue)
+}
+
+func (l *loader) parseDuration(key, source, valStr string, defaultValue time.Duration) time.Duration {
+	val, err := time.ParseDuration(valStr)
+	if err != nil {
+		l.errorf("invalid duration for %s (%s): %s, error: %v", key, source, valStr, err)
+		return defaultValue
+	}
+	return val
+}
+
+// getFloat получает float64 или значение по умолчанию
+func (l *loader) getFloat(key string, defaultValue float64) float64 {
+	valStr, source, exists := l.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		l.errorf("invalid float value for %s (%s): %s, error: %v", key, source, valStr, err)
+		return defaultValue
+	}
+	return val
+}
+
+// getBool получает bool или значение по умолчанию
+func (l *loader) getBool(key string, defaultValue bool) bool {
+	valStr, source, exists := l.lookup(key)
+	if !exists {
+		return defaultValue
+	}
+	val, err := strconv.ParseBool(valStr)
+	if err != nil {
+		l.errorf("invalid bool value for %s (%s): %s, error: %v", key, source, valStr, err)
+		return defaultValue
+	}
+	return val
+}
+
+// getList получает список значений через запятую или значение по умолчанию
+func (l *loader) getList(key string, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(l.get(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// newLoader собирает источники настроек по убыванию приоритета: флаги, переменные окружения,
+// файл конфигурации и .env файл окружения. Файл, заданный через ENV_FILE, обязателен, а файл по умолчанию нет
+func newLoader(args []string) *loader {
+	l := &loader{unreadable: make(map[string]bool)}
+
+	flags, err := parseFlags(args)
+	if err != nil {
+		l.errs = append(l.errs, err)
+	}
+	l.layers = append(l.layers, layer{name: flagsSource, values: flags}, layer{name: envSource, values: environ()})
+
+	if path, _, ok := l.lookup(configFileKey); ok {
+		values, err := readConfigFile(path)
+		if err != nil {
+			l.errs = append(l.errs, err...)
+		}
+		l.layers = append(l.layers, layer{name: path, values: values})
+	}
+
+	envFile, _, explicit := l.lookup(envFileKey)
+	if !explicit {
+		envFile = filepath.Join("..", "..", "internal", "config", ".env.dev")
+		if l.get(appEnvKey, DevEnvironment) == ProdEnvironment {
+			envFile = filepath.Join("..", "..", "internal", "config", ".env.prod")
+		}
+	}
+
+	// .env файл по умолчанию необязателен: без него настройки берутся из остальных источников
+	values, err := godotenv.Read(envFile)
+	switch {
+	case err == nil:
+		resolvePaths(values, filepath.Dir(envFile))
+		l.layers = append(l.layers, layer{name: envFile, values: values})
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		l.errorf("failed to read env file %s, error: %v", envFile, err)
+	}
+
+	return l
+}
+
+// resolvePaths делает относительные пути из .env файла относительными каталогу dir этого файла
+func resolvePaths(values map[string]string, dir string) {
+	for _, key := range pathKeys {
+		if path, ok := values[key]; ok && path != "" && !filepath.IsAbs(path) {
+			values[key] = filepath.Join(dir, path)
+		}
+	}
+}
+
+// parseFlags разбирает флаги вида -db-password=... для всех известных настроек
+func parseFlags(args []string) (map[string]string, error) {
+	fs := flag.NewFlagSet("everlock", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+
+	values := make(map[string]*string)
+	for _, key := range knownKeys() {
+		values[key] = fs.String(flagName(key), "", "overrides "+key)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		key := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		set[key] = *values[key]
+	})
+	return set, nil
+}
+
+// environ возвращает переменные окружения с именами известных настроек
+func environ() map[string]string {
+	values := make(map[string]string)
+	for _, key := range knownKeys() {
+		if value, ok := os.LookupEnv(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// readConfigFile читает плоский YAML или TOML файл, ключи которого совпадают с именами переменных окружения
+// в любом регистре, например, db_host: localhost. Списки допускаются как массивы или строки через запятую
+func readConfigFile(path string) (map[string]string, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read config file %s, error: %v", path, err)}
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(bytes.NewReader(data)).Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	default:
+		return nil, []error{fmt.Errorf("unsupported config file format: %s, expected .yaml, .yml or .toml", path)}
+	}
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to parse config file %s, error: %v", path, err)}
+	}
+
+	known := make(map[string]bool)
+	for _, key := range knownKeys() {
+		known[key] = true
+	}
+
+	var errs []error
+	values := make(map[string]string)
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := raw[name]
+		key := strings.ToUpper(name)
+		if !known[key] || key == configFileKey {
+			errs = append(errs, fmt.Errorf("unknown setting %s in config file %s", name, path))
+			continue
+		}
+
+		switch v := value.(type) {
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]any:
+			errs = append(errs, fmt.Errorf("setting %s in config file %s must be a value or a list", name, path))
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return values, errs
+}
+
+// knownKeys возвращает известные настройки вместе с вариантами _FILE для секретов
+func knownKeys() []string {
+	keys := append([]string{}, settingKeys...)
+	for _, key := range secretKeys {
+		keys = append(keys, key+fileSuffix)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isSecret(key string) bool {
+	for _, secret := range secretKeys {
+		if secret == key {
+			return true
+		}
+	}
+	return false
+}
+
+// flagName возвращает имя флага настройки: DB_PASSWORD -> db-password
+func flagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MaxPowDifficulty наибольшее число нулевых бит доказательства работы, которое браузер решает за разумное время
+const MaxPowDifficulty = 32
+
+// проверяемое значение настройки и ее имя для сообщения об ошибке
+type durationSetting struct {
+	key   string
+	value time.Duration
+}
+
+// validate проверяет значения, которые не проверяет ни один другой пакет: порты и диапазоны.
+// Сертификаты TLS, набор шифров, уровень журнала и подсети прокси проверяют пакеты, которые их используют
+func (c *App) validate() []error {
+	var errs []error
+
+	// пустые и нулевые значения пропускаются: об отсутствующих и неразобранных настройках уже сообщено
+	for _, port := range []struct{ key, value string }{{appPortKey, c.Web.Port}, {dbPortKey, c.Db.Port}} {
+		if n, err := strconv.Atoi(port.value); port.value != "" && (err != nil || n < 1 || n > 65535) {
+			errs = append(errs, fmt.Errorf("invalid %s: %s", port.key, port.value))
+		}
+	}
+
+	if c.Db.MaxConns < 0 {
+		errs = append(errs, fmt.Errorf("%s must be positive: %d", dbMaxConnsKey, c.Db.MaxConns))
+	}
+	if c.Db.MinConns < 0 || (c.Db.MaxConns > 0 && c.Db.MinConns > c.Db.MaxConns) {
+		errs = append(errs, fmt.Errorf("%s must be between 0 and %s: %d", dbMinConnsKey, dbMaxConnsKey, c.Db.MinConns))
+	}
+
+	// нулевой лимит частоты или запас запретили бы все запросы
+	for _, limit := range []struct {
+		key   string
+		value float64
+	}{
+		{rateIPPerMinuteKey, c.RateLimit.IPPerMinute},
+		{rateIPBurstKey, float64(c.RateLimit.IPBurst)},
+		{rateMsgPerMinuteKey, c.RateLimit.MessagePerMinute},
+		{rateMsgBurstKey, float64(c.RateLimit.MessageBurst)},
+		{createPerHourKey, c.Abuse.CreatePerHour},
+		{createBurstKey, float64(c.Abuse.CreateBurst)},
+	} {
+		if limit.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive: %v", limit.key, limit.value))
+		}
+	}
+
+	// ноль отключает блокировку, сложность доказательства работы и ограничение числа сообщений
+	if c.RateLimit.LockoutFailures < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative: %d", lockoutFailuresKey, c.RateLimit.LockoutFailures))
+	}
+	if c.Abuse.PowDifficulty < 0 || c.Abuse.PowDifficulty > MaxPowDifficulty {
+		errs = append(errs, fmt.Errorf("%s must be between 0 and %d: %d", powDifficultyKey, MaxPowDifficulty, c.Abuse.PowDifficulty))
+	}
+	if c.Abuse.MaxStoredMessages < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative: %d", maxStoredMessagesKey, c.Abuse.MaxStoredMessages))
+	}
+	if c.Web.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative: %d", maxHeaderBytesKey, c.Web.MaxHeaderBytes))
+	}
+
+	// нулевые таймауты HTTP сервера и БД и задержка остановки означают отсутствие ограничения или задержки
+	for _, timeout := range []durationSetting{
+		{readTimeoutKey, c.Web.ReadTimeout},
+		{readHeaderTimeoutKey, c.Web.ReadHeaderTimeout},
+		{writeTimeoutKey, c.Web.WriteTimeout},
+		{idleTimeoutKey, c.Web.IdleTimeout},
+		{shutdownDelayKey, c.Web.ShutdownDelay},
+		{dbStatementTimeoutKey, c.Db.StatementTimeout},
+		{dbQueryTimeoutKey, c.Db.QueryTimeout},
+	} {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative: %v", timeout.key, timeout.value))
+		}
+	}
+
+	// без этих сроков запросы при остановке отменялись бы сразу, уведомления не отправлялись бы,
+	// задачи доказательства работы истекали бы сразу, а блокировка не действовала бы
+	positive := []durationSetting{
+		{shutdownTimeoutKey, c.Web.ShutdownTimeout},
+		{notifyTimeoutKey, c.Notify.Timeout},
+		{powTTLKey, c.Abuse.PowTTL},
+	}
+	if c.RateLimit.LockoutFailures > 0 {
+		positive = append(positive, durationSetting{lockoutDurationKey, c.RateLimit.LockoutDuration})
+	}
+	for _, timeout := range positive {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive: %v", timeout.key, timeout.value))
+		}
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("%s must be between 0 and 1: %v", tracingSampleRatioKey, c.Tracing.SampleRatio))
+	}
+
+	if c.Templates.Reload && c.Templates.Dir == "" {
+		errs = append(errs, fmt.Errorf("%s requires %s", templateReloadKey, templateDirKey))
+	}
+
+	return errs
+}
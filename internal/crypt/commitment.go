@@ -0,0 +1,50 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// KeySaltSize размер соли обязательства ключа
+	KeySaltSize = 16
+
+	keyCommitmentInfo = "everlock key commitment v1"
+)
+
+// KeyCommitment вычисляет обязательство ключа с помощью HKDF-SHA256 с солью сообщения.
+// В отличие от хеша ключа, обязательство зависит от соли и контекста (идентификатора сообщения),
+// поэтому одинаковые ключи разных сообщений дают разные значения
+func KeyCommitment(key []byte, salt []byte, context []byte) ([32]byte, error) {
+	var commitment [32]byte
+
+	info := make([]byte, 0, len(keyCommitmentInfo)+len(context))
+	info = append(info, keyCommitmentInfo...)
+	info = append(info, context...)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, info), commitment[:]); err != nil {
+		return commitment, err
+	}
+
+	return commitment, nil
+}
+
+// VerifyKeyCommitment проверяет, что ключ соответствует обязательству, за постоянное время
+func VerifyKeyCommitment(key []byte, salt []byte, context []byte, expected [32]byte) bool {
+	actual, err := KeyCommitment(key, salt, context)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(actual[:], expected[:])
+}
+
+// VerifyLegacyKeyHash проверяет ключ по несоленому SHA-256, который хранился до появления обязательств
+func VerifyLegacyKeyHash(key []byte, expected [32]byte) bool {
+	actual := sha256.Sum256(key)
+	return subtle.ConstantTimeCompare(actual[:], expected[:]) == 1
+}
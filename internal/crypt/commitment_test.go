@@ -0,0 +1,62 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestKeyCommitment(t *testing.T) {
+	key := testKey(t)
+	salt := []byte("0123456789abcdef")
+	context := []byte("message 1")
+
+	commitment, err := KeyCommitment(key, salt, context)
+	if err != nil {
+		t.Fatalf("failed to compute commitment: %v", err)
+	}
+	if !VerifyKeyCommitment(key, salt, context, commitment) {
+		t.Fatal("commitment was not verified")
+	}
+	if commitment == sha256.Sum256(key) {
+		t.Fatal("commitment equals the unsalted key hash")
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		salt    []byte
+		context []byte
+	}{
+		{"other key", testKey(t), salt, context},
+		{"other salt", key, []byte("fedcba9876543210"), context},
+		{"no salt", key, nil, context},
+		{"other context", key, salt, []byte("message 2")},
+		{"no context", key, salt, nil},
+	}
+
+	for _, tt := range tests {
+		if VerifyKeyCommitment(tt.key, tt.salt, tt.context, commitment) {
+			t.Errorf("%s: commitment was verified", tt.name)
+		}
+	}
+}
+
+func TestVerifyLegacyKeyHash(t *testing.T) {
+	key := testKey(t)
+	hash := sha256.Sum256(key)
+
+	if !VerifyLegacyKeyHash(key, hash) {
+		t.Fatal("legacy hash was not verified")
+	}
+	if VerifyLegacyKeyHash(testKey(t), hash) {
+		t.Fatal("legacy hash was verified with another key")
+	}
+
+	commitment, err := KeyCommitment(key, []byte("0123456789abcdef"), []byte("message 1"))
+	if err != nil {
+		t.Fatalf("failed to compute commitment: %v", err)
+	}
+	if VerifyLegacyKeyHash(key, commitment) {
+		t.Fatal("commitment was verified as a legacy hash")
+	}
+}
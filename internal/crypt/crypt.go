@@ -9,6 +9,7 @@ import (
 )
 
 // EncryptAES шифрует переданные данные с использованием AES-GCM и возвращает nonce + cipher
+// в формате без заголовка; новые данные шифруются с помощью Encrypt
 func EncryptAES(plainData []byte, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -31,7 +32,7 @@ func EncryptAES(plainData []byte, key []byte) ([]byte, error) {
 	return append(nonce, cipher...), nil
 }
 
-// DecryptAES расшифровывает данные, зашифрованные с помощью AES-GCM
+// DecryptAES расшифровывает данные, зашифрованные с помощью EncryptAES
 func DecryptAES(encryptedData []byte, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
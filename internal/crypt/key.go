@@ -20,3 +20,14 @@ func GenerateRandomAESKey(keySize int) ([]byte, error) {
 
 	return key, nil
 }
+
+// генерирует случайную последовательность байт заданной длины
+func GenerateRandomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,39 @@
+package crypt
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/shamir"
+)
+
+// SplitSecret делит секрет на parts частей, любые threshold из которых восстанавливают секрет.
+// При пороге 1 каждая часть является копией секрета, так как схема Шамира требует порог не меньше 2
+func SplitSecret(secret []byte, parts, threshold int) ([][]byte, error) {
+	if threshold != 1 {
+		return shamir.Split(secret, parts, threshold)
+	}
+
+	if parts < 1 {
+		return nil, fmt.Errorf("parts cannot be less than threshold")
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = append([]byte(nil), secret...)
+	}
+
+	return shares, nil
+}
+
+// CombineSecret восстанавливает секрет из частей, полученных с помощью SplitSecret с тем же порогом
+func CombineSecret(shares [][]byte, threshold int) ([]byte, error) {
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("not enough shares: %d, threshold: %d", len(shares), threshold)
+	}
+
+	if threshold != 1 {
+		return shamir.Combine(shares)
+	}
+
+	return append([]byte(nil), shares[0]...), nil
+}
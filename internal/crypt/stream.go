@@ -0,0 +1,157 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// StreamChunkSize размер открытого текста в одном фрагменте потока
+	StreamChunkSize = 64 * 1024
+
+	// streamVersion3 версия формата потока: идентификатор алгоритма в заголовке и фрагменты, привязанные
+	// к метаданным через associated data. Версии 1 и 2 без associated data не выпускались
+	streamVersion3 = 3
+
+	// nonce фрагмента: префикс потока, 4 байта номера фрагмента и 1 байт признака последнего фрагмента
+	streamNonceSuffixSize = 5
+	streamKeyInfo         = "everlock attachment stream key"
+)
+
+// EncryptStream шифрует данные из src фрагментами по StreamChunkSize байт выбранным алгоритмом
+// и записывает в dst заголовок потока и зашифрованные фрагменты. Возвращает размер открытого текста.
+//
+// Ключ фрагментов выводится из key с помощью HKDF, nonce фрагмента состоит из случайного префикса потока,
+// номера фрагмента и признака последнего фрагмента, поэтому перестановка, удаление и обрезка фрагментов обнаруживаются.
+// additionalData аутентифицируется в каждом фрагменте
+func EncryptStream(dst io.Writer, src io.Reader, suite Suite, key []byte, additionalData []byte) (int64, error) {
+	aead, err := newStreamAEAD(suite, key)
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 2+aead.NonceSize()-streamNonceSuffixSize)
+	header[0], header[1] = streamVersion3, byte(suite)
+	prefix := header[2:]
+	if _, err = io.ReadFull(rand.Reader, prefix); err != nil {
+		return 0, err
+	}
+	if _, err = dst.Write(header); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	buf := make([]byte, StreamChunkSize)
+	sealed := make([]byte, 0, StreamChunkSize+aead.Overhead())
+
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, buf)
+		last := false
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return total, err
+		}
+		total += int64(n)
+
+		nonce := streamNonce(prefix, counter, last)
+		sealed = aead.Seal(sealed[:0], nonce, buf[:n], additionalData)
+		if _, err = dst.Write(sealed); err != nil {
+			return total, err
+		}
+
+		if last {
+			return total, nil
+		}
+		if counter == ^uint32(0) {
+			return total, fmt.Errorf("stream is too large")
+		}
+	}
+}
+
+// DecryptStream расшифровывает поток, созданный EncryptStream, и записывает открытый текст в dst
+// по мере проверки фрагментов. Алгоритм выбирается по заголовку потока, additionalData проверяется
+// в каждом фрагменте. Возвращает размер записанного открытого текста
+func DecryptStream(dst io.Writer, src io.Reader, key []byte, additionalData []byte) (int64, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return 0, fmt.Errorf("failed to read stream header, error: %v", err)
+	}
+
+	if version := header[0]; version != streamVersion3 {
+		return 0, fmt.Errorf("unsupported stream version: %d", version)
+	}
+	suite := Suite(header[1])
+
+	aead, err := newStreamAEAD(suite, key)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := make([]byte, aead.NonceSize()-streamNonceSuffixSize)
+	if _, err = io.ReadFull(src, prefix); err != nil {
+		return 0, fmt.Errorf("failed to read stream header, error: %v", err)
+	}
+
+	var total int64
+	buf := make([]byte, StreamChunkSize+aead.Overhead())
+	opened := make([]byte, 0, StreamChunkSize)
+
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, buf)
+		last := false
+		switch {
+		case errors.Is(err, io.EOF):
+			return total, fmt.Errorf("stream is truncated")
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return total, err
+		}
+
+		nonce := streamNonce(prefix, counter, last)
+		opened, err = aead.Open(opened[:0], nonce, buf[:n], additionalData)
+		if err != nil {
+			return total, fmt.Errorf("failed to decrypt chunk %d, error: %v", counter, err)
+		}
+
+		if _, err = dst.Write(opened); err != nil {
+			return total, err
+		}
+		total += int64(len(opened))
+
+		if last {
+			return total, nil
+		}
+		if counter == ^uint32(0) {
+			return total, fmt.Errorf("stream is too large")
+		}
+	}
+}
+
+func newStreamAEAD(suite Suite, key []byte) (cipher.AEAD, error) {
+	streamKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(streamKeyInfo)), streamKey); err != nil {
+		return nil, err
+	}
+
+	return suite.NewAEAD(streamKey)
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, len(prefix)+streamNonceSuffixSize)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
@@ -0,0 +1,113 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func encryptTestStream(t *testing.T, suite Suite, plain []byte, key []byte, ad []byte) []byte {
+	t.Helper()
+
+	var encrypted bytes.Buffer
+	size, err := EncryptStream(&encrypted, bytes.NewReader(plain), suite, key, ad)
+	if err != nil {
+		t.Fatalf("%v: encrypt stream: %v", suite, err)
+	}
+	if size != int64(len(plain)) {
+		t.Fatalf("%v: encrypted %d bytes, want %d", suite, size, len(plain))
+	}
+	return encrypted.Bytes()
+}
+
+func testPlaintext(t *testing.T, size int) []byte {
+	t.Helper()
+
+	plain := make([]byte, size)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatalf("failed to generate plaintext: %v", err)
+	}
+	return plain
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(t)
+	ad := []byte("attachment metadata")
+
+	sizes := []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 17}
+	for _, suite := range testSuites {
+		for _, size := range sizes {
+			plain := testPlaintext(t, size)
+			encrypted := encryptTestStream(t, suite, plain, key, ad)
+
+			var decrypted bytes.Buffer
+			n, err := DecryptStream(&decrypted, bytes.NewReader(encrypted), key, ad)
+			if err != nil {
+				t.Fatalf("%v: size %d: decrypt stream: %v", suite, size, err)
+			}
+			if n != int64(size) || !bytes.Equal(decrypted.Bytes(), plain) {
+				t.Fatalf("%v: size %d: decrypted stream does not match plaintext", suite, size)
+			}
+		}
+	}
+}
+
+func TestDecryptStreamRejectsTampering(t *testing.T) {
+	key := testKey(t)
+	ad := []byte("attachment metadata")
+
+	for _, suite := range testSuites {
+		aead, err := newStreamAEAD(suite, key)
+		if err != nil {
+			t.Fatalf("%v: %v", suite, err)
+		}
+		headerSize := 2 + aead.NonceSize() - streamNonceSuffixSize
+		chunkSize := StreamChunkSize + aead.Overhead()
+
+		tests := []struct {
+			name   string
+			tamper func(encrypted []byte) []byte
+		}{
+			{"version", func(e []byte) []byte { e[0] = 0; return e }},
+			{"version 2", func(e []byte) []byte { e[0] = 2; return e }},
+			{"suite", func(e []byte) []byte { e[1] = 0; return e }},
+			{"nonce prefix", func(e []byte) []byte { e[2] ^= 1; return e }},
+			{"first chunk", func(e []byte) []byte { e[headerSize] ^= 1; return e }},
+			{"last chunk", func(e []byte) []byte { e[len(e)-1] ^= 1; return e }},
+			{"swapped chunks", func(e []byte) []byte {
+				first := append([]byte{}, e[headerSize:headerSize+chunkSize]...)
+				copy(e[headerSize:], e[headerSize+chunkSize:headerSize+2*chunkSize])
+				copy(e[headerSize+chunkSize:], first)
+				return e
+			}},
+			{"truncated at chunk boundary", func(e []byte) []byte { return e[:headerSize+chunkSize] }},
+			{"truncated inside chunk", func(e []byte) []byte { return e[:headerSize+chunkSize+10] }},
+			{"truncated header", func(e []byte) []byte { return e[:headerSize-1] }},
+			{"appended chunk", func(e []byte) []byte { return append(e, e[headerSize:headerSize+chunkSize]...) }},
+		}
+
+		for _, tt := range tests {
+			encrypted := encryptTestStream(t, suite, testPlaintext(t, 2*StreamChunkSize+100), key, ad)
+
+			var decrypted bytes.Buffer
+			if _, err := DecryptStream(&decrypted, bytes.NewReader(tt.tamper(encrypted)), key, ad); err == nil {
+				t.Errorf("%v: %s: tampered stream was decrypted", suite, tt.name)
+			}
+		}
+	}
+}
+
+func TestDecryptStreamRejectsWrongAssociatedData(t *testing.T) {
+	key := testKey(t)
+
+	for _, suite := range testSuites {
+		encrypted := encryptTestStream(t, suite, testPlaintext(t, StreamChunkSize+1), key, []byte("attachment 1"))
+
+		for _, ad := range [][]byte{nil, []byte("attachment 2")} {
+			var decrypted bytes.Buffer
+			if _, err := DecryptStream(&decrypted, bytes.NewReader(encrypted), key, ad); err == nil {
+				t.Errorf("%v: stream was decrypted with associated data %q", suite, ad)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Suite идентификатор алгоритма AEAD, записываемый в заголовок шифротекста
+type Suite byte
+
+const (
+	SuiteAES256GCM         Suite = 1
+	SuiteXChaCha20Poly1305 Suite = 2
+
+	// CiphertextLegacy шифротекст EncryptAES без заголовка и associated data, записанный до появления версий
+	CiphertextLegacy byte = 0
+	// CiphertextVersion2 версия формата шифротекста: заголовок, nonce и шифротекст, привязанный к метаданным
+	// через associated data. Версия 1 без associated data не выпускалась
+	CiphertextVersion2 byte = 2
+
+	ciphertextMagic0 byte = 'E'
+	ciphertextMagic1 byte = 'L'
+	headerSize            = 4
+)
+
+var suiteNames = map[Suite]string{
+	SuiteAES256GCM:         "aes-256-gcm",
+	SuiteXChaCha20Poly1305: "xchacha20-poly1305",
+}
+
+// ParseSuite возвращает алгоритм по его имени в конфигурации
+func ParseSuite(name string) (Suite, error) {
+	for suite, suiteName := range suiteNames {
+		if suiteName == name {
+			return suite, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite: %s", name)
+}
+
+func (s Suite) String() string {
+	if name, ok := suiteNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("suite(%d)", byte(s))
+}
+
+// NewAEAD создает AEAD алгоритма для заданного ключа
+func (s Suite) NewAEAD(key []byte) (cipher.AEAD, error) {
+	switch s {
+	case SuiteAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case SuiteXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unknown cipher suite: %d", byte(s))
+	}
+}
+
+// Encrypt шифрует данные выбранным алгоритмом и возвращает заголовок + nonce + cipher.
+// Заголовок содержит сигнатуру формата, его версию и идентификатор алгоритма.
+// Заголовок и additionalData аутентифицируются вместе с шифротекстом
+func Encrypt(suite Suite, plainData []byte, key []byte, additionalData []byte) ([]byte, error) {
+	aead, err := suite.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(plainData)+aead.Overhead())
+	out[0], out[1], out[2], out[3] = ciphertextMagic0, ciphertextMagic1, CiphertextVersion2, byte(suite)
+
+	nonce := out[headerSize:]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, nonce, plainData, associatedData(out[:headerSize], additionalData)), nil
+}
+
+// Decrypt расшифровывает данные, записанные Encrypt, выбирая алгоритм по заголовку шифротекста,
+// и проверяет заголовок и additionalData. Данные другого формата не расшифровываются: шифротекст
+// без заголовка расшифровывается DecryptAES, только если запись отмечена как записанная до появления версий
+func Decrypt(encryptedData []byte, key []byte, additionalData []byte) ([]byte, error) {
+	suite, err := parseHeader(encryptedData)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptWithSuite(suite, encryptedData[headerSize:], key, associatedData(encryptedData[:headerSize], additionalData))
+}
+
+// parseHeader проверяет заголовок шифротекста и возвращает алгоритм
+func parseHeader(encryptedData []byte) (Suite, error) {
+	if len(encryptedData) < headerSize || encryptedData[0] != ciphertextMagic0 || encryptedData[1] != ciphertextMagic1 {
+		return 0, fmt.Errorf("ciphertext has no header")
+	}
+
+	if version := encryptedData[2]; version != CiphertextVersion2 {
+		return 0, fmt.Errorf("unsupported ciphertext version: %d", version)
+	}
+
+	suite := Suite(encryptedData[3])
+	if _, known := suiteNames[suite]; !known {
+		return 0, fmt.Errorf("unknown cipher suite: %d", byte(suite))
+	}
+
+	return suite, nil
+}
+
+func decryptWithSuite(suite Suite, data []byte, key []byte, additionalData []byte) ([]byte, error) {
+	aead, err := suite.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("cipher is too short; cipher size: %d, nonce size: %d", len(data), nonceSize)
+	}
+
+	nonce, cipherText := data[:nonceSize], data[nonceSize:]
+
+	return aead.Open(nil, nonce, cipherText, additionalData)
+}
+
+func associatedData(header []byte, additionalData []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(additionalData))
+	ad = append(ad, header...)
+	return append(ad, additionalData...)
+}
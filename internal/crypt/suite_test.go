@@ -0,0 +1,139 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+var testSuites = []Suite{SuiteAES256GCM, SuiteXChaCha20Poly1305}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := GenerateRandomAESKey(32)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := testKey(t)
+	ad := []byte("message metadata")
+
+	for _, suite := range testSuites {
+		for _, plain := range [][]byte{{}, []byte("secret"), bytes.Repeat([]byte{'x'}, 1000)} {
+			encrypted, err := Encrypt(suite, plain, key, ad)
+			if err != nil {
+				t.Fatalf("%v: encrypt: %v", suite, err)
+			}
+
+			decrypted, err := Decrypt(encrypted, key, ad)
+			if err != nil {
+				t.Fatalf("%v: decrypt: %v", suite, err)
+			}
+			if !bytes.Equal(decrypted, plain) {
+				t.Fatalf("%v: decrypted %q, want %q", suite, decrypted, plain)
+			}
+		}
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := testKey(t)
+	ad := []byte("message metadata")
+
+	tests := []struct {
+		name   string
+		tamper func(encrypted []byte) []byte
+	}{
+		{"magic", func(e []byte) []byte { e[0] ^= 1; return e }},
+		{"version", func(e []byte) []byte { e[2]++; return e }},
+		{"suite", func(e []byte) []byte {
+			if Suite(e[3]) == SuiteAES256GCM {
+				e[3] = byte(SuiteXChaCha20Poly1305)
+			} else {
+				e[3] = byte(SuiteAES256GCM)
+			}
+			return e
+		}},
+		{"nonce", func(e []byte) []byte { e[headerSize] ^= 1; return e }},
+		{"ciphertext", func(e []byte) []byte { e[len(e)-1] ^= 1; return e }},
+		{"truncated", func(e []byte) []byte { return e[:len(e)-1] }},
+		{"version 1", func(e []byte) []byte { e[2] = 1; return e }},
+		{"header only", func(e []byte) []byte { return e[:headerSize] }},
+	}
+
+	for _, suite := range testSuites {
+		for _, tt := range tests {
+			encrypted, err := Encrypt(suite, []byte("secret"), key, ad)
+			if err != nil {
+				t.Fatalf("%v: encrypt: %v", suite, err)
+			}
+
+			if _, err = Decrypt(tt.tamper(encrypted), key, ad); err == nil {
+				t.Errorf("%v: %s: tampered ciphertext was decrypted", suite, tt.name)
+			}
+		}
+	}
+}
+
+func TestDecryptRejectsWrongAssociatedData(t *testing.T) {
+	key := testKey(t)
+
+	for _, suite := range testSuites {
+		encrypted, err := Encrypt(suite, []byte("secret"), key, []byte("message 1"))
+		if err != nil {
+			t.Fatalf("%v: encrypt: %v", suite, err)
+		}
+
+		for _, ad := range [][]byte{nil, []byte("message 2"), []byte("message 1 ")} {
+			if _, err = Decrypt(encrypted, key, ad); err == nil {
+				t.Errorf("%v: ciphertext was decrypted with associated data %q", suite, ad)
+			}
+		}
+	}
+}
+
+func TestDecryptRejectsDowngrade(t *testing.T) {
+	key := testKey(t)
+	ad := []byte("message metadata")
+	plain := []byte("secret")
+
+	legacy, err := EncryptAES(plain, key)
+	if err != nil {
+		t.Fatalf("encrypt legacy: %v", err)
+	}
+
+	// заголовок версии 1, шифротекст которой не привязан к метаданным
+	aead, err := SuiteAES256GCM.NewAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	header := []byte{ciphertextMagic0, ciphertextMagic1, 1, byte(SuiteAES256GCM)}
+	version1 := aead.Seal(append(header, nonce...), nonce, plain, nil)
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+	}{
+		{"legacy without header", legacy},
+		{"version 1 without associated data", version1},
+	}
+
+	for _, tt := range tests {
+		if _, err := Decrypt(tt.encrypted, key, ad); err == nil {
+			t.Errorf("%s: ciphertext was decrypted", tt.name)
+		}
+	}
+
+	decrypted, err := DecryptAES(legacy, key)
+	if err != nil || !bytes.Equal(decrypted, plain) {
+		t.Fatalf("legacy ciphertext was not decrypted by DecryptAES: %v", err)
+	}
+}
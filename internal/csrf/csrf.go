@@ -0,0 +1,198 @@
+package csrf
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const (
+	// TokenField имя поля формы с токеном; в multipart-формах поле должно идти первым
+	TokenField = "csrfToken"
+
+	cookieName    = "everlock_csrf"
+	headerName    = "X-CSRF-Token"
+	tokenSize     = 32
+	jsonMIME      = "application/json"
+	formMIME      = "application/x-www-form-urlencoded"
+	multipartMIME = "multipart/form-data"
+	// сколько байт начала multipart-тела читается в поисках поля с токеном
+	maxLeadingPartSize = 4096
+	cookieMaxAge       = 24 * 60 * 60
+)
+
+type contextKey struct{}
+
+// Protect защищает изменяющие запросы двойной отправкой токена: токен хранится в cookie
+// и должен быть повторен в заголовке X-CSRF-Token или в поле формы. В multipart-формах токен читается
+// только из первого поля, чтобы проверка не требовала разбора всего тела. Origin и Referer изменяющих запросов
+// должны совпадать с адресом сервера или доверенным источником. JSON-запросы проверяются только
+// по Origin и Referer: браузер не отправит их на чужой сервер без CORS
+func Protect(c *config.Web, next http.Handler) http.Handler {
+	trustedOrigins := make(map[string]bool, len(c.TrustedOrigins))
+	for _, origin := range c.TrustedOrigins {
+		trustedOrigins[strings.ToLower(origin)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := cookieToken(r)
+		if token == "" {
+			var err error
+			if token, err = newToken(); err != nil {
+				slog.ErrorContext(r.Context(), "failed to generate csrf token", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     cookieName,
+				Value:    token,
+				Path:     "/",
+				MaxAge:   cookieMaxAge,
+				HttpOnly: true,
+				Secure:   c.SecureCookies || r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if !isSafeMethod(r.Method) {
+			if !sameOrigin(r, trustedOrigins) {
+				reject(w, r, "origin mismatch")
+				return
+			}
+
+			if !isJSON(r) && !validToken(r, cookieToken(r)) {
+				reject(w, r, "invalid csrf token")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, token)))
+	})
+}
+
+// Token возвращает токен запроса для подстановки в формы шаблонов
+func Token(r *http.Request) string {
+	token, _ := r.Context().Value(contextKey{}).(string)
+	return token
+}
+
+func newToken() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func cookieToken(r *http.Request) string {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+	if decoded, err := base64.RawURLEncoding.DecodeString(cookie.Value); err != nil || len(decoded) != tokenSize {
+		return ""
+	}
+	return cookie.Value
+}
+
+func validToken(r *http.Request, expected string) bool {
+	if expected == "" {
+		return false
+	}
+
+	actual := r.Header.Get(headerName)
+	if actual == "" {
+		switch mediaType(r) {
+		case formMIME:
+			actual = r.PostFormValue(TokenField)
+		case multipartMIME:
+			actual = multipartToken(r)
+		}
+	}
+
+	return subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) == 1
+}
+
+// читает токен из первого поля multipart-формы. Прочитанное начало тела возвращается в r.Body,
+// чтобы обработчик разобрал форму целиком
+func multipartToken(r *http.Request) string {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return ""
+	}
+
+	body := r.Body
+	var consumed bytes.Buffer
+	defer func() {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&consumed, body), body}
+	}()
+
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, maxLeadingPartSize), &consumed), params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil || part.FormName() != TokenField || part.FileName() != "" {
+		return ""
+	}
+
+	token, err := io.ReadAll(io.LimitReader(part, 2*tokenSize))
+	if err != nil {
+		return ""
+	}
+	return string(token)
+}
+
+// сравнивает источник из Origin, а без него из Referer, с адресом сервера и доверенными источниками.
+// Запросы без обоих заголовков пропускаются: их отправляют не браузеры
+func sameOrigin(r *http.Request, trustedOrigins map[string]bool) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return trustedOrigins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isJSON(r *http.Request) bool {
+	return mediaType(r) == jsonMIME
+}
+
+func mediaType(r *http.Request) string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+func reject(w http.ResponseWriter, r *http.Request, reason string) {
+	slog.WarnContext(r.Context(), "csrf check failed", "path", r.URL.Path, "method", r.Method, "reason", reason)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
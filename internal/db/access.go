@@ -2,224 +2,698 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/tracing"
 )
 
-func SaveNewMessage(p *pgxpool.Pool, m *model.Message) error {
-	tx, err := p.Begin(context.Background())
+func SaveNewMessage(ctx context.Context, p *pgxpool.Pool, m *model.Message) error {
+	ctx, span := tracing.Start(ctx, "db.SaveNewMessage")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction, error: %v", err)
+		return fmt.Errorf("failed to start transaction, error: %w", err)
 	}
 
 	var txErr error
 
 	defer func() {
 		if txErr != nil {
-			if rbErr := tx.Rollback(context.Background()); rbErr != nil {
-				log.Printf("Failed to rollback transaction, error: %v", rbErr)
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
 			}
 		}
 	}()
 
-	if txErr = saveMessage(tx, m); txErr != nil {
+	if txErr = saveMessage(ctx, tx, m); txErr != nil {
 		return txErr
 	}
 
 	for _, key := range m.Keys {
-		if txErr = saveMessageKey(tx, key); txErr != nil {
+		if txErr = saveMessageKey(ctx, tx, key); txErr != nil {
+			return txErr
+		}
+	}
+
+	for _, attachment := range m.Attachments {
+		if txErr = saveAttachment(ctx, tx, attachment); txErr != nil {
 			return txErr
 		}
 	}
 
-	txErr = tx.Commit(context.Background())
+	txErr = tx.Commit(ctx)
 	if txErr != nil {
-		return fmt.Errorf("failed to commit transaction, error: %v", txErr)
+		return fmt.Errorf("failed to commit transaction, error: %w", txErr)
 	}
 
 	return nil
 }
 
-func GetMessageByID(p *pgxpool.Pool, u *uuid.UUID) (*model.Message, error) {
-	tx, err := p.Begin(context.Background())
+// CountMessages возвращает число хранимых сообщений
+func CountMessages(ctx context.Context, p *pgxpool.Pool) (int64, error) {
+	ctx, span := tracing.Start(ctx, "db.CountMessages")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var count int64
+	if err := p.QueryRow(ctx, `SELECT count(*) FROM message`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count messages, error: %w", err)
+	}
+	return count, nil
+}
+
+func GetMessageByID(ctx context.Context, p *pgxpool.Pool, u *uuid.UUID) (*model.Message, error) {
+	ctx, span := tracing.Start(ctx, "db.GetMessageByID")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start transaction, error: %v", err)
+		return nil, fmt.Errorf("failed to start transaction, error: %w", err)
 	}
 
 	var txErr error
 
 	defer func() {
 		if txErr != nil {
-			if rbErr := tx.Rollback(context.Background()); rbErr != nil {
-				log.Printf("Failed to rollback transaction, error: %v", rbErr)
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
 			}
 		}
 	}()
 
-	message, txErr := fetchMessage(tx, u)
+	message, txErr := fetchMessage(ctx, tx, u)
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	keys, txErr := fetchMessageKeys(ctx, tx, u)
 	if txErr != nil {
 		return nil, txErr
 	}
 
-	keys, txErr := fetchMessageKeys(tx, u)
+	attachments, txErr := fetchAttachments(ctx, tx, u)
 	if txErr != nil {
-		return nil, err
+		return nil, txErr
 	}
 
 	message.Keys = keys
+	message.Attachments = attachments
+	if message.Policy == nil {
+		message.Policy = model.NewSingleGroupPolicy(len(keys), message.MinKeyholders)
+	}
 
-	txErr = tx.Commit(context.Background())
+	txErr = tx.Commit(ctx)
 	if txErr != nil {
-		return nil, fmt.Errorf("failed to commit transaction, error: %v", txErr)
+		return nil, fmt.Errorf("failed to commit transaction, error: %w", txErr)
 	}
 
 	return message, nil
 }
 
-func UpdateKeySecret(p *pgxpool.Pool, k *model.MessageKey) error {
+// UpdateKeySecret сохраняет введенную часть ключа и событие журнала audit в одной транзакции
+func UpdateKeySecret(ctx context.Context, p *pgxpool.Pool, k *model.MessageKey, audit *Audit) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateKeySecret")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		UPDATE message_key 
-		SET secret_part = $1, updated_at = now() 
-		WHERE id = $2
+		SET secret_part = $1, signature = $2, submitted_by = NULLIF($3, ''), updated_at = now() 
+		WHERE id = $4
+	`
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction, error: %w", err)
+	}
+
+	var txErr error
+
+	defer func() {
+		if txErr != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
+			}
+		}
+	}()
+
+	cmdTag, txErr := tx.Exec(ctx, query, k.SecretPart, k.Signature, k.SubmittedBy, k.ID)
+	if txErr != nil {
+		return fmt.Errorf("failed to update key with id %v, error: %w", k.ID, txErr)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		txErr = fmt.Errorf("key %v: %w", k.ID, ErrNotFound)
+		return txErr
+	}
+
+	txErr = appendAuditEvents(ctx, tx, audit)
+	if txErr != nil {
+		return txErr
+	}
+
+	txErr = tx.Commit(ctx)
+	if txErr != nil {
+		return fmt.Errorf("failed to commit transaction, error: %w", txErr)
+	}
+
+	return nil
+}
+
+// UpdateEncryptedContent заменяет шифротекст старого формата шифротекстом версии version,
+// если он не изменился с момента чтения
+func UpdateEncryptedContent(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, oldContent []byte, newContent []byte, version byte) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateEncryptedContent")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE message
+		SET encrypted_content = $3, content_version = $4
+		WHERE id = $1 AND encrypted_content = $2 AND content_version = 0
+	`
+
+	cmdTag, err := p.Exec(ctx, query, mid, oldContent, newContent, int16(version))
+	if err != nil {
+		return fmt.Errorf("failed to update encrypted content of message %v, error: %w", mid, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("encrypted content of message %v was changed concurrently: %w", mid, ErrConflict)
+	}
+
+	return nil
+}
+
+// UpdateKeyCommitment заменяет несоленый хеш ключа старой записи обязательством ключа
+func UpdateKeyCommitment(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, salt []byte, commitment [32]byte) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateKeyCommitment")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE message
+		SET key_salt = $2, key_commitment = $3
+		WHERE id = $1 AND key_salt IS NULL
 	`
 
-	cmdTag, err := p.Exec(context.Background(), query, k.SecretPart, k.ID)
+	cmdTag, err := p.Exec(ctx, query, mid, salt, commitment[:])
 	if err != nil {
-		return fmt.Errorf("failed to update key with id %v, error: %v", k.ID, err)
+		return fmt.Errorf("failed to update key commitment of message %v, error: %w", mid, err)
 	}
 
 	if cmdTag.RowsAffected() == 0 {
-		return fmt.Errorf("no key found with id: %v", k.ID)
+		return fmt.Errorf("key commitment of message %v was already upgraded: %w", mid, ErrConflict)
 	}
 
 	return nil
 }
 
-func CleanKeysByMsgID(p *pgxpool.Pool, mid *uuid.UUID) error {
+// CleanKeysByMsgID удаляет введенные части ключа сообщения и сохраняет событие журнала audit в одной транзакции
+func CleanKeysByMsgID(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, audit *Audit) error {
+	ctx, span := tracing.Start(ctx, "db.CleanKeysByMsgID")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		UPDATE message_key
-		SET secret_part = NULL
+		SET secret_part = NULL, signature = NULL, submitted_by = NULL
 		WHERE message_id = $1
 	`
 
-	tx, err := p.Begin(context.Background())
+	tx, err := p.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction, error: %v", err)
+		return fmt.Errorf("failed to start transaction, error: %w", err)
 	}
 
 	var txErr error
 
 	defer func() {
 		if txErr != nil {
-			if rbErr := tx.Rollback(context.Background()); rbErr != nil {
-				log.Printf("Failed to rollback transaction, error: %v", rbErr)
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
 			}
 		}
 	}()
 
 	_, txErr = tx.Exec(
-		context.Background(),
+		ctx,
 		query,
 		mid,
 	)
 	if txErr != nil {
-		return fmt.Errorf("failed to update message_key by message_id %v, error: %v", mid, txErr)
+		return fmt.Errorf("failed to update message_key by message_id %v, error: %w", mid, txErr)
+	}
+
+	_, txErr = tx.Exec(
+		ctx,
+		`UPDATE message SET threshold_met_at = NULL WHERE id = $1`,
+		mid,
+	)
+	if txErr != nil {
+		return fmt.Errorf("failed to reset release window of message %v, error: %w", mid, txErr)
+	}
+
+	txErr = appendAuditEvents(ctx, tx, audit)
+	if txErr != nil {
+		return txErr
 	}
 
-	txErr = tx.Commit(context.Background())
+	txErr = tx.Commit(ctx)
 	if txErr != nil {
-		return fmt.Errorf("failed to commit transaction, error: %v", txErr)
+		return fmt.Errorf("failed to commit transaction, error: %w", txErr)
+	}
+
+	return nil
+}
+
+// GetLockedUntil возвращает время окончания блокировки клиента client для сообщения или nil, если клиент не заблокирован
+func GetLockedUntil(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, client string) (*time.Time, error) {
+	ctx, span := tracing.Start(ctx, "db.GetLockedUntil")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT locked_until
+		FROM client_lockout
+		WHERE message_id = $1 AND client = $2 AND locked_until > now()
+	`
+
+	var lockedUntil time.Time
+	err := p.QueryRow(ctx, query, mid, client).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch lockout of message %v, error: %w", mid, err)
+	}
+
+	return &lockedUntil, nil
+}
+
+// RecordFailedCombine учитывает неудачное восстановление ключа для каждого из клиентов clients. Когда число неудач
+// клиента подряд достигает maxFailures, клиент блокируется для сообщения на lockout, а его счетчик сбрасывается;
+// возвращает число заблокированных этим вызовом клиентов и время окончания их блокировки
+func RecordFailedCombine(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, clients []string, maxFailures int, lockout time.Duration) (int, *time.Time, error) {
+	ctx, span := tracing.Start(ctx, "db.RecordFailedCombine")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO client_lockout AS l (message_id, client, failed_combines, locked_until)
+		SELECT $1, client,
+			CASE WHEN 1 >= $3 THEN 0 ELSE 1 END,
+			CASE WHEN 1 >= $3 THEN now() + make_interval(secs => $4) END
+		FROM unnest($2::text[]) AS client
+		ON CONFLICT (message_id, client) DO UPDATE
+		SET failed_combines = CASE WHEN l.failed_combines + 1 >= $3 THEN 0 ELSE l.failed_combines + 1 END,
+			locked_until = CASE WHEN l.failed_combines + 1 >= $3 THEN now() + make_interval(secs => $4) ELSE l.locked_until END
+		RETURNING failed_combines = 0, locked_until
+	`
+
+	rows, err := p.Query(ctx, query, mid, clients, maxFailures, lockout.Seconds())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to record failed combine of message %v, error: %w", mid, err)
+	}
+	defer rows.Close()
+
+	var lockedClients int
+	var lockedUntil *time.Time
+	for rows.Next() {
+		var locked bool
+		var until *time.Time
+		if err = rows.Scan(&locked, &until); err != nil {
+			return 0, nil, fmt.Errorf("failed to scan lockout, error: %w", err)
+		}
+		if locked {
+			lockedClients++
+			lockedUntil = until
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("failed to record failed combine of message %v, error: %w", mid, err)
+	}
+
+	return lockedClients, lockedUntil, nil
+}
+
+// ResetFailedCombines сбрасывает счетчики неудачных восстановлений после успешной расшифровки;
+// действующие блокировки клиентов сохраняются
+func ResetFailedCombines(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID) error {
+	ctx, span := tracing.Start(ctx, "db.ResetFailedCombines")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		DELETE FROM client_lockout
+		WHERE message_id = $1 AND (locked_until IS NULL OR locked_until <= now())
+	`
+
+	if _, err := p.Exec(ctx, query, mid); err != nil {
+		return fmt.Errorf("failed to reset failed combines of message %v, error: %w", mid, err)
 	}
 
 	return nil
 }
 
-func saveMessage(tx pgx.Tx, m *model.Message) error {
+// StartReleaseWindow отмечает время набора порога частей ключа, если окно вето еще не начато.
+// Возвращает время начала окна и признак того, что окно начато этим вызовом
+func StartReleaseWindow(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, now time.Time) (time.Time, bool, error) {
+	ctx, span := tracing.Start(ctx, "db.StartReleaseWindow")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE message
+		SET threshold_met_at = $2
+		WHERE id = $1 AND threshold_met_at IS NULL
+		RETURNING threshold_met_at
+	`
+
+	var thresholdMetAt time.Time
+
+	err := p.QueryRow(ctx, query, mid, now).Scan(&thresholdMetAt)
+	if err == nil {
+		return thresholdMetAt, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, false, fmt.Errorf("failed to start release window for message %v, error: %w", mid, err)
+	}
+
+	err = p.QueryRow(ctx, `SELECT threshold_met_at FROM message WHERE id = $1`, mid).Scan(&thresholdMetAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, false, fmt.Errorf("message %v: %w", mid, ErrNotFound)
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to fetch release window for message %v, error: %w", mid, err)
+	}
+
+	return thresholdMetAt, false, nil
+}
+
+// VetoRelease отменяет раскрытие сообщения: сбрасывает окно вето и удаляет введенные части ключа.
+// События журнала audit сохраняются в той же транзакции
+func VetoRelease(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, audit *Audit) error {
+	ctx, span := tracing.Start(ctx, "db.VetoRelease")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction, error: %w", err)
+	}
+
+	var txErr error
+
+	defer func() {
+		if txErr != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
+			}
+		}
+	}()
+
+	cmdTag, txErr := tx.Exec(
+		ctx,
+		`UPDATE message SET threshold_met_at = NULL, vetoed_at = now() WHERE id = $1 AND threshold_met_at IS NOT NULL`,
+		mid,
+	)
+	if txErr != nil {
+		return fmt.Errorf("failed to veto release of message %v, error: %w", mid, txErr)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		txErr = fmt.Errorf("no pending release for message %v: %w", mid, ErrConflict)
+		return txErr
+	}
+
+	_, txErr = tx.Exec(
+		ctx,
+		`UPDATE message_key SET secret_part = NULL, signature = NULL, submitted_by = NULL, updated_at = now() WHERE message_id = $1`,
+		mid,
+	)
+	if txErr != nil {
+		return fmt.Errorf("failed to update message_key by message_id %v, error: %w", mid, txErr)
+	}
+
+	txErr = appendAuditEvents(ctx, tx, audit)
+	if txErr != nil {
+		return txErr
+	}
+
+	txErr = tx.Commit(ctx)
+	if txErr != nil {
+		return fmt.Errorf("failed to commit transaction, error: %w", txErr)
+	}
+
+	return nil
+}
+
+// DeleteMessage удаляет сообщение с частями ключа и метаданными вложений и сохраняет событие журнала audit
+// в той же транзакции. Журнал сообщения не удаляется
+func DeleteMessage(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID, audit *Audit) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteMessage")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction, error: %w", err)
+	}
+
+	var txErr error
+
+	defer func() {
+		if txErr != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
+			}
+		}
+	}()
+
+	_, txErr = tx.Exec(ctx, `DELETE FROM message_key WHERE message_id = $1`, mid)
+	if txErr != nil {
+		return fmt.Errorf("failed to delete keys of message %v, error: %w", mid, txErr)
+	}
+
+	_, txErr = tx.Exec(ctx, `DELETE FROM attachment WHERE message_id = $1`, mid)
+	if txErr != nil {
+		return fmt.Errorf("failed to delete attachments of message %v, error: %w", mid, txErr)
+	}
+
+	cmdTag, txErr := tx.Exec(ctx, `DELETE FROM message WHERE id = $1`, mid)
+	if txErr != nil {
+		return fmt.Errorf("failed to delete message %v, error: %w", mid, txErr)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		txErr = fmt.Errorf("message %v: %w", mid, ErrNotFound)
+		return txErr
+	}
+
+	txErr = appendAuditEvents(ctx, tx, audit)
+	if txErr != nil {
+		return txErr
+	}
+
+	txErr = tx.Commit(ctx)
+	if txErr != nil {
+		return fmt.Errorf("failed to commit transaction, error: %w", txErr)
+	}
+
+	return nil
+}
+
+func saveMessage(ctx context.Context, tx pgx.Tx, m *model.Message) error {
+	policy, err := json.Marshal(m.Policy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy of message %v, error: %w", m.ID, err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO message (id, creator_name, encrypted_content, content_version, key_commitment, key_salt, min_keyholders, policy, release_delay_seconds, veto_hash, notify_url, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12)`,
+		m.ID, m.CreatorName, m.EncryptedContent, int16(m.ContentVersion), m.KeyCommitment[:], m.KeySalt, m.MinKeyholders, policy, int64(m.ReleaseDelay/time.Second), m.VetoHash, m.NotifyURL, m.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save message %v, error: %w", m.ID, err)
+	}
+
+	return nil
+}
+
+func saveMessageKey(ctx context.Context, tx pgx.Tx, key model.MessageKey) error {
 	_, err := tx.Exec(
-		context.Background(),
-		`INSERT INTO message (id, creator_name, encrypted_content, key_hash, min_keyholders, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
-		m.ID, m.CreatorName, m.EncryptedContent, m.KeyHash[:], m.MinKeyholders, m.CreatedAt,
+		ctx,
+		`INSERT INTO message_key (id, message_id, group_index, secret_part, veto_hash, signing_key, notify_url, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)`,
+		key.ID, key.MessageID, key.GroupIndex, key.SecretPart, key.VetoHash, key.SigningKey, key.NotifyURL, key.UpdatedAt,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to save message %v, error: %v", m, err)
+		return fmt.Errorf("failed to save key share %v of message %v, error: %w", key.ID, key.MessageID, err)
 	}
 
 	return nil
 }
 
-func saveMessageKey(tx pgx.Tx, key model.MessageKey) error {
+func saveAttachment(ctx context.Context, tx pgx.Tx, a model.Attachment) error {
 	_, err := tx.Exec(
-		context.Background(),
-		`INSERT INTO message_key (id, message_id, secret_part, updated_at) VALUES ($1, $2, $3, $4)`,
-		key.ID, key.MessageID, key.SecretPart, key.UpdatedAt,
+		ctx,
+		`INSERT INTO attachment (id, message_id, encrypted_name, size, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		a.ID, a.MessageID, a.EncryptedName, a.Size, a.CreatedAt,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to save key share %v, error: %v", key, err)
+		return fmt.Errorf("failed to save attachment %v, error: %w", a.ID, err)
 	}
 
 	return nil
 }
 
-func fetchMessage(tx pgx.Tx, u *uuid.UUID) (*model.Message, error) {
+func fetchMessage(ctx context.Context, tx pgx.Tx, u *uuid.UUID) (*model.Message, error) {
 	var message model.Message
 
 	query := `
-		SELECT id, creator_name, encrypted_content, key_hash, min_keyholders, created_at
+		SELECT id, creator_name, encrypted_content, content_version, key_commitment, key_salt, min_keyholders, policy,
+			release_delay_seconds, threshold_met_at, vetoed_at, veto_hash, COALESCE(notify_url, ''), created_at
 		FROM message
 		WHERE id = $1
 	`
 
-	var hash []byte
+	var contentVersion int16
+	var commitment []byte
+	var policy []byte
+	var releaseDelaySeconds int64
 
-	err := tx.QueryRow(context.Background(), query, u).Scan(
+	err := tx.QueryRow(ctx, query, u).Scan(
 		&message.ID,
 		&message.CreatorName,
 		&message.EncryptedContent,
-		&hash,
+		&contentVersion,
+		&commitment,
+		&message.KeySalt,
 		&message.MinKeyholders,
+		&policy,
+		&releaseDelaySeconds,
+		&message.ThresholdMetAt,
+		&message.VetoedAt,
+		&message.VetoHash,
+		&message.NotifyURL,
 		&message.CreatedAt,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("message %v: %w", u, ErrNotFound)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch message, error: %v", err)
+		return nil, fmt.Errorf("failed to fetch message, error: %w", err)
 	}
 
-	message.KeyHash = [32]byte(hash)
+	message.ContentVersion = byte(contentVersion)
+	message.KeyCommitment = [32]byte(commitment)
+	message.ReleaseDelay = time.Duration(releaseDelaySeconds) * time.Second
+
+	if policy != nil {
+		message.Policy = &model.Policy{}
+		if err = json.Unmarshal(policy, message.Policy); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal policy of message %v, error: %w", message.ID, err)
+		}
+	}
 
 	return &message, nil
 }
 
-func fetchMessageKeys(tx pgx.Tx, u *uuid.UUID) ([]model.MessageKey, error) {
+func fetchMessageKeys(ctx context.Context, tx pgx.Tx, u *uuid.UUID) ([]model.MessageKey, error) {
 	var keys []model.MessageKey
 
 	keysQuery := `
-		SELECT id, message_id, secret_part, updated_at
+		SELECT id, message_id, group_index, secret_part, veto_hash, signing_key, signature, COALESCE(submitted_by, ''), COALESCE(notify_url, ''), updated_at
 		FROM message_key
 		WHERE message_id = $1
 	`
 
-	rows, err := tx.Query(context.Background(), keysQuery, u)
+	rows, err := tx.Query(ctx, keysQuery, u)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch message keys, error: %v", err)
+		return nil, fmt.Errorf("failed to fetch message keys, error: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var key model.MessageKey
-		if err = rows.Scan(&key.ID, &key.MessageID, &key.SecretPart, &key.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan message key, error: %v", err)
+		if err = rows.Scan(&key.ID, &key.MessageID, &key.GroupIndex, &key.SecretPart, &key.VetoHash, &key.SigningKey, &key.Signature, &key.SubmittedBy, &key.NotifyURL, &key.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message key, error: %w", err)
 		}
 		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows iteration error: %v", err)
+		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
 
 	return keys, nil
 }
+
+func fetchAttachments(ctx context.Context, tx pgx.Tx, u *uuid.UUID) ([]model.Attachment, error) {
+	var attachments []model.Attachment
+
+	query := `
+		SELECT id, message_id, encrypted_name, size, created_at
+		FROM attachment
+		WHERE message_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := tx.Query(ctx, query, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments, error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a model.Attachment
+		if err = rows.Scan(&a.ID, &a.MessageID, &a.EncryptedName, &a.Size, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment, error: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/tracing"
+)
+
+// Audit события журнала, которые добавляются в той же транзакции, что и изменение состояния,
+// и секрет их хешей. Если событие не удалось добавить, изменение не сохраняется
+type Audit struct {
+	Events []*model.AuditEvent
+	Secret []byte
+}
+
+// AppendAuditEvent добавляет запись в конец цепочки журнала сообщения, вычисляя ее хеш с секретом secret
+func AppendAuditEvent(ctx context.Context, p *pgxpool.Pool, e *model.AuditEvent, secret []byte) error {
+	ctx, span := tracing.Start(ctx, "db.AppendAuditEvent")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction, error: %w", err)
+	}
+
+	var txErr error
+
+	defer func() {
+		if txErr != nil {
+			if rbErr := tx.Rollback(ctx); rbErr != nil {
+				slog.ErrorContext(ctx, "failed to rollback transaction", "error", rbErr)
+			}
+		}
+	}()
+
+	txErr = appendAuditEvents(ctx, tx, &Audit{Events: []*model.AuditEvent{e}, Secret: secret})
+	if txErr != nil {
+		return txErr
+	}
+
+	txErr = tx.Commit(ctx)
+	if txErr != nil {
+		return fmt.Errorf("failed to commit transaction, error: %w", txErr)
+	}
+
+	return nil
+}
+
+// добавляет события в конец цепочек журнала в транзакции tx. Добавление для одного сообщения
+// сериализуется advisory-блокировкой до конца транзакции, чтобы параллельные записи не ответвляли цепочку
+func appendAuditEvents(ctx context.Context, tx pgx.Tx, a *Audit) error {
+	if a == nil {
+		return nil
+	}
+
+	for _, e := range a.Events {
+		_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))`, e.MessageID)
+		if err != nil {
+			return fmt.Errorf("failed to lock audit chain of message %v, error: %w", e.MessageID, err)
+		}
+
+		var prev *model.AuditEvent
+		last := model.AuditEvent{MessageID: e.MessageID}
+		var lastHash []byte
+
+		err = tx.QueryRow(
+			ctx,
+			`SELECT seq, hash FROM audit_event WHERE message_id = $1 ORDER BY seq DESC LIMIT 1`,
+			e.MessageID,
+		).Scan(&last.Seq, &lastHash)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return fmt.Errorf("failed to fetch last audit event of message %v, error: %w", e.MessageID, err)
+		default:
+			last.Hash = [32]byte(lastHash)
+			prev = &last
+		}
+
+		e.Chain(prev, a.Secret)
+
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO audit_event (message_id, seq, event_type, details, created_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			e.MessageID, e.Seq, e.Type, e.Details, e.CreatedAt, e.PrevHash[:], e.Hash[:],
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save audit event of message %v, error: %w", e.MessageID, err)
+		}
+	}
+
+	return nil
+}
+
+// GetAuditEvents возвращает цепочку журнала сообщения по возрастанию номера записи
+func GetAuditEvents(ctx context.Context, p *pgxpool.Pool, mid *uuid.UUID) ([]model.AuditEvent, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAuditEvents")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var events []model.AuditEvent
+
+	query := `
+		SELECT message_id, seq, event_type, details, created_at, prev_hash, hash
+		FROM audit_event
+		WHERE message_id = $1
+		ORDER BY seq
+	`
+
+	rows, err := p.Query(ctx, query, mid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit events, error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.AuditEvent
+		var prevHash, hash []byte
+		if err = rows.Scan(&e.MessageID, &e.Seq, &e.Type, &e.Details, &e.CreatedAt, &prevHash, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event, error: %w", err)
+		}
+		e.PrevHash, e.Hash = [32]byte(prevHash), [32]byte(hash)
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,16 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound запись не найдена
+	ErrNotFound = errors.New("not found")
+	// ErrConflict запись уже изменена другим запросом или находится в другом состоянии
+	ErrConflict = errors.New("conflict")
+
+	// ErrInviteAlreadyRegistered открытый ключ по приглашению уже зарегистрирован
+	ErrInviteAlreadyRegistered = fmt.Errorf("invite is already registered: %w", ErrConflict)
+)
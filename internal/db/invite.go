@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/tracing"
+)
+
+func SaveInvite(ctx context.Context, p *pgxpool.Pool, i *model.Invite) error {
+	ctx, span := tracing.Start(ctx, "db.SaveInvite")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := p.Exec(
+		ctx,
+		`INSERT INTO keyholder_invite (id, label, created_at) VALUES ($1, $2, $3)`,
+		i.ID, i.Label, i.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save invite %v, error: %w", i.ID, err)
+	}
+
+	return nil
+}
+
+func GetInviteByID(ctx context.Context, p *pgxpool.Pool, u *uuid.UUID) (*model.Invite, error) {
+	ctx, span := tracing.Start(ctx, "db.GetInviteByID")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var invite model.Invite
+	var publicKey *string
+
+	query := `
+		SELECT id, label, public_key, key_type, created_at, registered_at
+		FROM keyholder_invite
+		WHERE id = $1
+	`
+
+	err := p.QueryRow(ctx, query, u).Scan(
+		&invite.ID,
+		&invite.Label,
+		&publicKey,
+		&invite.KeyType,
+		&invite.CreatedAt,
+		&invite.RegisteredAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("invite %v: %w", u, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invite %v, error: %w", u, err)
+	}
+
+	if publicKey != nil {
+		invite.PublicKey = *publicKey
+	}
+
+	return &invite, nil
+}
+
+// RegisterInvitePublicKey сохраняет открытый ключ хранителя; ключ регистрируется по приглашению только один раз
+func RegisterInvitePublicKey(ctx context.Context, p *pgxpool.Pool, i *model.Invite) error {
+	ctx, span := tracing.Start(ctx, "db.RegisterInvitePublicKey")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE keyholder_invite
+		SET public_key = $1, key_type = $2, registered_at = now()
+		WHERE id = $3 AND public_key IS NULL
+		RETURNING registered_at
+	`
+
+	err := p.QueryRow(ctx, query, i.PublicKey, i.KeyType, i.ID).Scan(&i.RegisteredAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInviteAlreadyRegistered
+	}
+	if err != nil {
+		return fmt.Errorf("failed to register public key of invite %v, error: %w", i.ID, err)
+	}
+
+	return nil
+}
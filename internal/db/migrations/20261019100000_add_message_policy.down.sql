@@ -0,0 +1,2 @@
+ALTER TABLE message_key DROP COLUMN IF EXISTS group_index;
+ALTER TABLE message DROP COLUMN IF EXISTS policy;
@@ -0,0 +1,6 @@
+ALTER TABLE message ADD COLUMN IF NOT EXISTS policy JSONB;
+ALTER TABLE message_key ADD COLUMN IF NOT EXISTS group_index INT NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN message.policy IS 'иерархическая пороговая политика (группы хранителей и их пороги), NULL для сообщений с одной группой';
+
+COMMENT ON COLUMN message_key.group_index IS 'индекс группы политики, к которой относится часть ключа';
@@ -0,0 +1,7 @@
+ALTER TABLE message_key DROP COLUMN IF EXISTS notify_url;
+ALTER TABLE message DROP COLUMN IF EXISTS notify_url;
+ALTER TABLE message_key DROP COLUMN IF EXISTS veto_hash;
+ALTER TABLE message DROP COLUMN IF EXISTS veto_hash;
+ALTER TABLE message DROP COLUMN IF EXISTS vetoed_at;
+ALTER TABLE message DROP COLUMN IF EXISTS threshold_met_at;
+ALTER TABLE message DROP COLUMN IF EXISTS release_delay_seconds;
@@ -0,0 +1,24 @@
+ALTER TABLE message ADD COLUMN IF NOT EXISTS release_delay_seconds BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS threshold_met_at TIMESTAMPTZ;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS vetoed_at TIMESTAMPTZ;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS veto_hash BYTEA;
+ALTER TABLE message ADD CONSTRAINT message_release_delay_check CHECK (release_delay_seconds >= 0);
+ALTER TABLE message ADD CONSTRAINT message_veto_hash_check CHECK (octet_length(veto_hash) = 32);
+ALTER TABLE message_key ADD COLUMN IF NOT EXISTS veto_hash BYTEA;
+ALTER TABLE message_key ADD CONSTRAINT message_key_veto_hash_check CHECK (octet_length(veto_hash) = 32);
+ALTER TABLE message ADD COLUMN IF NOT EXISTS notify_url VARCHAR(2048);
+ALTER TABLE message_key ADD COLUMN IF NOT EXISTS notify_url VARCHAR(2048);
+
+COMMENT ON COLUMN message.release_delay_seconds IS 'окно вето в секундах между набором порога частей ключа и раскрытием сообщения';
+
+COMMENT ON COLUMN message.threshold_met_at IS 'время набора порога частей ключа, начало окна вето';
+
+COMMENT ON COLUMN message.vetoed_at IS 'время последнего вето раскрытия сообщения';
+
+COMMENT ON COLUMN message.veto_hash IS 'хеш кода вето создателя сообщения (SHA-256)';
+
+COMMENT ON COLUMN message_key.veto_hash IS 'хеш кода вето хранителя ключа (SHA-256)';
+
+COMMENT ON COLUMN message.notify_url IS 'адрес webhook, на который создатель получает уведомления об окне вето';
+
+COMMENT ON COLUMN message_key.notify_url IS 'адрес webhook, на который хранитель части ключа получает уведомления об окне вето';
@@ -0,0 +1,23 @@
+CREATE TABLE attachment (
+    id UUID PRIMARY KEY,
+    message_id UUID NOT NULL REFERENCES message(id),
+    encrypted_name BYTEA NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    CHECK (octet_length(encrypted_name) <= 1024),
+    CHECK (size >= 0)
+);
+
+CREATE INDEX idx_attachment_message_id ON attachment (message_id);
+
+COMMENT ON TABLE attachment is 'таблица для хранения метаданных зашифрованных вложений сообщений, содержимое хранится в хранилище объектов';
+
+COMMENT ON COLUMN attachment.id IS 'уникальный идентификатор вложения и его объекта в хранилище';
+
+COMMENT ON COLUMN attachment.message_id IS 'связь с сообщением';
+
+COMMENT ON COLUMN attachment.encrypted_name IS 'зашифрованное имя файла';
+
+COMMENT ON COLUMN attachment.size IS 'размер открытого содержимого в байтах';
+
+COMMENT ON COLUMN attachment.created_at IS 'дата загрузки вложения';
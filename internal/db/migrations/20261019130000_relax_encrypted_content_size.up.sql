@@ -0,0 +1,4 @@
+ALTER TABLE message DROP CONSTRAINT IF EXISTS message_encrypted_content_check;
+ALTER TABLE message ADD CONSTRAINT message_encrypted_content_check CHECK (octet_length(encrypted_content) <= 1088);
+
+COMMENT ON COLUMN message.encrypted_content IS 'зашифрованное сообщение: заголовок с версией формата и алгоритмом, nonce и шифротекст (у старых записей без заголовка)';
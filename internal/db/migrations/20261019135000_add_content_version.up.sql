@@ -0,0 +1,5 @@
+ALTER TABLE message ADD COLUMN content_version SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE message ALTER COLUMN content_version DROP DEFAULT;
+ALTER TABLE message ADD CONSTRAINT message_content_version_check CHECK (content_version IN (0, 2));
+
+COMMENT ON COLUMN message.content_version IS 'версия формата encrypted_content: 0 - без заголовка и привязки к метаданным у записей, созданных до появления версий, 2 - заголовок и шифротекст, привязанный к метаданным сообщения';
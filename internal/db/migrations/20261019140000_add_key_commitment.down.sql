@@ -0,0 +1,4 @@
+ALTER TABLE message DROP COLUMN IF EXISTS key_salt;
+ALTER TABLE message RENAME COLUMN key_commitment TO key_hash;
+
+COMMENT ON COLUMN message.key_hash IS 'хеш оригинального ключа (SHA-256)';
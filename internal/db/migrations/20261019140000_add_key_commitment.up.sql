@@ -0,0 +1,7 @@
+ALTER TABLE message RENAME COLUMN key_hash TO key_commitment;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS key_salt BYTEA;
+ALTER TABLE message ADD CONSTRAINT message_key_salt_check CHECK (octet_length(key_salt) = 16);
+
+COMMENT ON COLUMN message.key_commitment IS 'обязательство ключа (HKDF-SHA256 с солью и идентификатором сообщения), у старых записей без соли - SHA-256 ключа';
+
+COMMENT ON COLUMN message.key_salt IS 'соль обязательства ключа, NULL для старых записей с несоленым хешем';
@@ -0,0 +1,21 @@
+CREATE TABLE keyholder_invite (
+    id UUID PRIMARY KEY,
+    label VARCHAR(64) NOT NULL,
+    public_key TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    registered_at TIMESTAMPTZ,
+    CHECK (octet_length(public_key) <= 128),
+    CHECK ((public_key IS NULL) = (registered_at IS NULL))
+);
+
+COMMENT ON TABLE keyholder_invite is 'таблица приглашений хранителей, по которым они регистрируют открытые ключи для шифрования своих частей ключа';
+
+COMMENT ON COLUMN keyholder_invite.id IS 'уникальный идентификатор приглашения, входит в ссылку приглашения';
+
+COMMENT ON COLUMN keyholder_invite.label IS 'подпись приглашения, по которой создатель сообщения узнает хранителя';
+
+COMMENT ON COLUMN keyholder_invite.public_key IS 'открытый ключ age (X25519) хранителя, NULL до регистрации';
+
+COMMENT ON COLUMN keyholder_invite.created_at IS 'дата создания приглашения';
+
+COMMENT ON COLUMN keyholder_invite.registered_at IS 'дата регистрации открытого ключа';
@@ -0,0 +1,7 @@
+DELETE FROM keyholder_invite WHERE key_type <> 'age';
+
+ALTER TABLE keyholder_invite DROP COLUMN key_type;
+
+ALTER TABLE keyholder_invite DROP CONSTRAINT keyholder_invite_public_key_check;
+
+ALTER TABLE keyholder_invite ADD CONSTRAINT keyholder_invite_public_key_check CHECK (octet_length(public_key) <= 128);
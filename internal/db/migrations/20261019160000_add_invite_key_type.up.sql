@@ -0,0 +1,11 @@
+ALTER TABLE keyholder_invite DROP CONSTRAINT keyholder_invite_public_key_check;
+
+ALTER TABLE keyholder_invite ADD CONSTRAINT keyholder_invite_public_key_check CHECK (octet_length(public_key) <= 16384);
+
+ALTER TABLE keyholder_invite ADD COLUMN key_type VARCHAR(16) NOT NULL DEFAULT 'age';
+
+ALTER TABLE keyholder_invite ADD CONSTRAINT keyholder_invite_key_type_check CHECK (key_type IN ('age', 'openpgp'));
+
+COMMENT ON COLUMN keyholder_invite.public_key IS 'открытый ключ хранителя: ключ age (X25519) или открытый ключ OpenPGP в текстовом формате, NULL до регистрации';
+
+COMMENT ON COLUMN keyholder_invite.key_type IS 'тип открытого ключа: age или openpgp';
@@ -0,0 +1,3 @@
+ALTER TABLE message_key DROP COLUMN signature;
+
+ALTER TABLE message_key DROP COLUMN signing_key;
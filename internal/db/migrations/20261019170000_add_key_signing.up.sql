@@ -0,0 +1,7 @@
+ALTER TABLE message_key ADD COLUMN signing_key BYTEA CHECK (octet_length(signing_key) = 32);
+
+ALTER TABLE message_key ADD COLUMN signature BYTEA CHECK (octet_length(signature) = 64);
+
+COMMENT ON COLUMN message_key.signing_key IS 'открытый ключ Ed25519 хранителя, которым должна быть подписана вводимая часть ключа; NULL, если подпись не требуется';
+
+COMMENT ON COLUMN message_key.signature IS 'подпись Ed25519 введенной части ключа и идентификатора сообщения';
@@ -0,0 +1,40 @@
+CREATE TABLE audit_event (
+    message_id UUID NOT NULL,
+    seq BIGINT NOT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    details TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    prev_hash BYTEA NOT NULL,
+    hash BYTEA NOT NULL,
+    PRIMARY KEY (message_id, seq),
+    CHECK (seq > 0),
+    CHECK (octet_length(details) <= 4096),
+    CHECK (octet_length(prev_hash) = 32),
+    CHECK (octet_length(hash) = 32)
+);
+
+CREATE FUNCTION audit_event_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_event_append_only
+    BEFORE UPDATE OR DELETE ON audit_event
+    FOR EACH ROW EXECUTE FUNCTION audit_event_append_only();
+
+COMMENT ON TABLE audit_event is 'журнал событий сообщений только для добавления; записи сообщения связаны в цепочку хешей';
+
+COMMENT ON COLUMN audit_event.message_id IS 'идентификатор сообщения; без внешнего ключа, чтобы журнал переживал удаление сообщения';
+
+COMMENT ON COLUMN audit_event.seq IS 'номер записи в цепочке сообщения, начиная с 1';
+
+COMMENT ON COLUMN audit_event.event_type IS 'тип события';
+
+COMMENT ON COLUMN audit_event.details IS 'детали события в JSON с отсортированными ключами, хешируются как есть';
+
+COMMENT ON COLUMN audit_event.created_at IS 'время события с точностью до микросекунд';
+
+COMMENT ON COLUMN audit_event.prev_hash IS 'хеш предыдущей записи цепочки, нули для первой записи';
+
+COMMENT ON COLUMN audit_event.hash IS 'HMAC-SHA256 полей записи и хеша предыдущей записи с секретом сервера AUDIT_SECRET';
@@ -0,0 +1,3 @@
+ALTER TABLE message_key DROP COLUMN submitted_by;
+
+DROP TABLE IF EXISTS client_lockout;
@@ -0,0 +1,21 @@
+CREATE TABLE client_lockout (
+    message_id UUID NOT NULL REFERENCES message(id) ON DELETE CASCADE,
+    client VARCHAR(64) NOT NULL,
+    failed_combines INT NOT NULL DEFAULT 0 CHECK (failed_combines >= 0),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (message_id, client)
+);
+
+COMMENT ON TABLE client_lockout is 'неудачные восстановления ключа сообщения по клиентам и блокировки клиентов';
+
+COMMENT ON COLUMN client_lockout.message_id IS 'связь с сообщением';
+
+COMMENT ON COLUMN client_lockout.client IS 'SHA-256 адреса клиента в hex; сам адрес не хранится';
+
+COMMENT ON COLUMN client_lockout.failed_combines IS 'число неудачных восстановлений ключа подряд с последней блокировки клиента или успешной расшифровки';
+
+COMMENT ON COLUMN client_lockout.locked_until IS 'время, до которого клиенту заблокированы ввод частей ключа и расшифровка сообщения';
+
+ALTER TABLE message_key ADD COLUMN submitted_by VARCHAR(64);
+
+COMMENT ON COLUMN message_key.submitted_by IS 'SHA-256 адреса клиента, который ввел часть ключа, в hex';
@@ -3,13 +3,29 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/tracing"
 )
 
+// queryTimeout ограничивает одно обращение к БД, ноль отключает ограничение
+var queryTimeout time.Duration
+
+// withTimeout ограничивает контекст запроса к БД сроком queryTimeout.
+// Отмена исходного контекста, например, при закрытии соединения клиентом, по-прежнему прерывает запрос
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
 // LoadDbPool создает пул подключений к базе данных, выполняет пинг и возвращает пул,
 // если соединение с БД успешно установлено. В случае ошибки завершает программу с ошибкой.
 func LoadDbPool(c *config.Db) *pgxpool.Pool {
@@ -22,7 +38,8 @@ func LoadDbPool(c *config.Db) *pgxpool.Pool {
 
 	dbConfig, err := pgxpool.ParseConfig(connString)
 	if err != nil {
-		log.Fatalf("Failed to create a dbConfig, error: %v", err)
+		slog.Error("failed to create a dbConfig", "error", err)
+		os.Exit(1)
 	}
 
 	dbConfig.MaxConns = c.MaxConns
@@ -31,18 +48,25 @@ func LoadDbPool(c *config.Db) *pgxpool.Pool {
 	dbConfig.MaxConnIdleTime = c.MaxConnIdleTime
 	dbConfig.HealthCheckPeriod = c.HealthCheckPeriod
 	dbConfig.ConnConfig.ConnectTimeout = c.ConnectTimeout
+	dbConfig.ConnConfig.Tracer = tracing.QueryTracer{}
+	if c.StatementTimeout > 0 {
+		dbConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	queryTimeout = c.QueryTimeout
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), dbConfig)
 	if err != nil {
-		log.Fatalf("Unable to create DbPool, error: %v", err)
+		slog.Error("unable to create DbPool", "error", err)
+		os.Exit(1)
 	}
 
 	err = pool.Ping(context.Background())
 	if err != nil {
 		pool.Close()
-		log.Fatalf("Unable to connect to database, error: %v", err)
+		slog.Error("unable to connect to database", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("DbPool successfully created and connected")
+	slog.Info("DbPool successfully created and connected")
 	return pool
 }
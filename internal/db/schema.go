@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/tracing"
+)
+
+// миграции встраиваются в бинарник, чтобы сервер знал, какую версию схемы он ожидает
+//
+//go:embed migrations/*.up.sql
+var migrations embed.FS
+
+const migrationSuffix = ".up.sql"
+
+// ExpectedSchemaVersion возвращает версию последней миграции, с которой собран сервер
+func ExpectedSchemaVersion() (uint64, error) {
+	files, err := fs.Glob(migrations, "migrations/*"+migrationSuffix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list migrations, error: %w", err)
+	}
+
+	var latest uint64
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		prefix, _, found := strings.Cut(name, "_")
+		if !found {
+			return 0, fmt.Errorf("invalid migration name: %s", name)
+		}
+
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid migration version: %s, error: %w", name, err)
+		}
+		latest = max(latest, version)
+	}
+
+	return latest, nil
+}
+
+// GetSchemaVersion возвращает версию схемы из таблицы schema_migrations golang-migrate
+// и признак незавершенной миграции
+func GetSchemaVersion(ctx context.Context, p *pgxpool.Pool) (uint64, bool, error) {
+	ctx, span := tracing.Start(ctx, "db.GetSchemaVersion")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var version int64
+	var dirty bool
+
+	err := p.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to fetch schema version, error: %w", err)
+	}
+
+	return uint64(version), dirty, nil
+}
+
+// Ping проверяет доступность базы данных
+func Ping(ctx context.Context, p *pgxpool.Pool) error {
+	ctx, span := tracing.Start(ctx, "db.Ping")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if err := p.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping database, error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const (
+	// RequestIDHeader заголовок с идентификатором запроса, принимается от прокси и возвращается клиенту
+	RequestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	traceIDKey      = "trace_id"
+	requestIDSize   = 16
+	redacted        = "[REDACTED]"
+)
+
+// идентификатор от клиента принимается, только если он не может испортить журнал
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// атрибуты с такими именами никогда не попадают в журнал, даже если их передали по ошибке
+var sensitiveKeys = map[string]bool{
+	"share":             true,
+	"shares":            true,
+	"secret":            true,
+	"secret_part":       true,
+	"plaintext":         true,
+	"message_text":      true,
+	"encrypted_content": true,
+	"password":          true,
+	"signature":         true,
+	"veto_code":         true,
+	"csrf_token":        true,
+	"token":             true,
+}
+
+type contextKey struct{}
+
+// Setup настраивает журнал slog в формате JSON с уровнем из конфигурации и делает его журналом по умолчанию,
+// в том числе для пакета log
+func Setup(c *config.Log) error {
+	level, err := parseLevel(c)
+	if err != nil {
+		return err
+	}
+
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	return nil
+}
+
+// Validate проверяет уровень журнала, не меняя журнал по умолчанию
+func Validate(c *config.Log) error {
+	_, err := parseLevel(c)
+	return err
+}
+
+func parseLevel(c *config.Log) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return level, fmt.Errorf("invalid log level: %s, error: %v", c.Level, err)
+	}
+	return level, nil
+}
+
+// заменяет значения чувствительных атрибутов
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// добавляет к записям идентификатор запроса и трассы из контекста
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(requestIDKey, id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(traceIDKey, sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestID возвращает идентификатор запроса из контекста или пустую строку
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware присваивает запросу идентификатор, передает его дальше в контексте и в заголовке ответа
+// и пишет в журнал итог запроса. В журнал попадает только путь: строка запроса может содержать токены
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), contextKey{}, id)
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		slog.InfoContext(ctx, "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+		)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, requestIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// запоминает код ответа обработчика
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap дает http.ResponseController доступ к исходному ResponseWriter
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,62 @@
+package logic
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/pow"
+)
+
+const (
+	powChallengeKey  = "powChallenge"
+	powNonceKey      = "powNonce"
+	powDifficultyKey = "powDifficulty"
+)
+
+// проверяет, что создание сообщения разрешено: не превышено число хранимых сообщений и решена задача
+// доказательства работы. Поля задачи передаются в строке запроса, чтобы проверить их до разбора тела формы.
+// Возвращает код ответа, если создание запрещено
+func checkCreationAllowed(p *pgxpool.Pool, ac *config.Abuse, pw *pow.Issuer, r *http.Request) (int, error) {
+	ctx := r.Context()
+
+	if ac.MaxStoredMessages > 0 {
+		count, err := db.CountMessages(ctx, p)
+		if err != nil {
+			return errorStatus(err, http.StatusInternalServerError), err
+		}
+		if count >= ac.MaxStoredMessages {
+			return http.StatusServiceUnavailable, fmt.Errorf("stored messages limit reached: %d, max: %d", count, ac.MaxStoredMessages)
+		}
+	}
+
+	if pw.Enabled() {
+		query := r.URL.Query()
+		if err := pw.Verify(query.Get(powChallengeKey), query.Get(powNonceKey), time.Now()); err != nil {
+			return errorStatus(err, http.StatusForbidden), fmt.Errorf("proof of work rejected, error: %w", err)
+		}
+	}
+
+	return 0, nil
+}
+
+// добавляет задачу доказательства работы к данным шаблона главной страницы
+func addChallenge(data map[string]interface{}, pw *pow.Issuer) error {
+	if !pw.Enabled() {
+		return nil
+	}
+
+	challenge, err := pw.NewChallenge()
+	if err != nil {
+		return err
+	}
+
+	data[powChallengeKey] = challenge
+	data[powDifficultyKey] = pw.Difficulty()
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/blob"
+	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/crypt"
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/metrics"
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/ratelimit"
+	"github.com/mclyashko/everlock/internal/tracing"
+)
+
+const (
+	maxAttachmentsAllowed        = 5
+	maxAttachmentSizeAllowed     = 16 << 20
+	maxAttachmentNameSizeAllowed = 255
+	maxSubmitRequestSize         = maxAttachmentsAllowed*maxAttachmentSizeAllowed + 1<<20
+	// maxFormFieldsSize суммарный размер полей формы без вложений
+	maxFormFieldsSize            = 1 << 20
+	defaultAttachmentContentType = "application/octet-stream"
+	attachmentsKey               = "attachments"
+	attachmentIDKey              = "attachmentID"
+	attachmentNameKey            = "attachmentName"
+	attachmentSizeKey            = "attachmentSize"
+)
+
+// отдает расшифрованное вложение сообщения потоком, если набран порог частей ключа и истекло окно вето
+func AttachmentHandler(p *pgxpool.Pool, s blob.Store, rl *config.RateLimit, ip *ratelimit.ClientIP, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 2 {
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid attachment url: %s", r.URL.Path), http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := uuid.Parse(parts[len(parts)-2])
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	attachmentID, err := uuid.Parse(parts[len(parts)-1])
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	message, err := db.GetMessageByID(ctx, p, &messageID)
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to get message, error: %v", err), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	client := clientID(ip, r)
+	if respondIfLocked(w, r, p, message, client) {
+		return
+	}
+
+	var attachment *model.Attachment
+	for _, a := range message.Attachments {
+		if a.ID == attachmentID {
+			attachment = &a
+		}
+	}
+	if attachment == nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("attachment %v not found in message %v", attachmentID, messageID), http.StatusNotFound)
+		return
+	}
+
+	shares := collectShares(message)
+	if _, groupsComplete := policyProgress(shares, message.Policy); groupsComplete < message.Policy.Threshold {
+		logErrorAndRespond(w, r, fmt.Sprintf("message %v is not unlocked", messageID), http.StatusConflict)
+		return
+	}
+
+	if message.ReleaseDelay > 0 {
+		releaseAt, pending := message.ReleaseAt()
+		if !pending || time.Now().Before(releaseAt) {
+			logErrorAndRespond(w, r, fmt.Sprintf("release of message %v is pending", messageID), http.StatusConflict)
+			return
+		}
+	}
+
+	combinedKey, err := recoverKey(ctx, p, rl, message, shares, client)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	name, err := crypt.Decrypt(attachment.EncryptedName, combinedKey, attachment.AssociatedData())
+	if err != nil {
+		metrics.Reconstructions.WithLabelValues(metrics.ReconstructionDecryptError).Inc()
+		logErrorAndRespond(w, r, fmt.Sprintf("error decrypting attachment name, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	src, err := s.Open(attachment.ID.String())
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to open attachment, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(string(name)))
+	if contentType == "" {
+		contentType = defaultAttachmentContentType
+	}
+
+	// вложение без записи в журнале не выдается
+	err = recordRequiredEvent(ctx, p, messageID, model.AuditAttachmentDownloaded, map[string]string{"attachment_id": attachment.ID.String()})
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics.Reconstructions.WithLabelValues(metrics.ReconstructionSuccess).Inc()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": string(name)}))
+
+	_, span := tracing.Start(ctx, "crypto.decrypt_attachment")
+	_, err = crypt.DecryptStream(w, src, combinedKey, attachment.AssociatedData())
+	tracing.End(span, err)
+	if err != nil {
+		// заголовки уже отправлены, поэтому обрываем соединение, чтобы клиент не принял обрезанный файл
+		slog.ErrorContext(ctx, "failed to stream attachment", "attachment_id", attachment.ID, "message_id", messageID, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// шифрует вложения потоком в хранилище по мере чтения тела запроса и добавляет их метаданные к сообщению.
+// Открытый текст вложений не сохраняется ни в памяти целиком, ни во временных файлах
+func storeAttachments(s blob.Store, m *model.Message, u *uploads, cs crypt.Suite, key []byte) error {
+	for {
+		part, err := u.nextPart()
+		if err != nil || part == nil {
+			return err
+		}
+
+		if part.FormName() != attachmentsKey {
+			return fmt.Errorf("%w: unexpected form field %s after attachments", errInvalidAttachment, part.FormName())
+		}
+
+		// пустое поле выбора файлов
+		name := part.FileName()
+		if name == "" {
+			continue
+		}
+
+		if len(m.Attachments) == maxAttachmentsAllowed {
+			return fmt.Errorf("%w: too many attachments, max: %d", errInvalidAttachment, maxAttachmentsAllowed)
+		}
+
+		if len(name) > maxAttachmentNameSizeAllowed {
+			return fmt.Errorf("%w: attachment name is too large, size: %d", errInvalidAttachment, len(name))
+		}
+
+		a := m.NewAttachment()
+
+		encryptedName, err := crypt.Encrypt(cs, []byte(name), key, a.AssociatedData())
+		if err != nil {
+			return fmt.Errorf("failed to encrypt attachment name, error: %v", err)
+		}
+		a.EncryptedName = encryptedName
+
+		a.Size, err = storeAttachment(s, &a, part, cs, key)
+		if err != nil {
+			return err
+		}
+		m.Attachments = append(m.Attachments, a)
+	}
+}
+
+// шифрует одно вложение в хранилище. При ошибке недописанный объект удаляется и не публикуется
+func storeAttachment(s blob.Store, a *model.Attachment, src io.Reader, cs crypt.Suite, key []byte) (int64, error) {
+	dst, err := s.Create(a.ID.String())
+	if err != nil {
+		return 0, err
+	}
+
+	// читается на байт больше допустимого, чтобы отличить вложение предельного размера от слишком большого
+	size, err := crypt.EncryptStream(dst, io.LimitReader(src, maxAttachmentSizeAllowed+1), cs, key, a.AssociatedData())
+	if err != nil {
+		_ = dst.Abort()
+		return 0, fmt.Errorf("failed to encrypt attachment, error: %w", err)
+	}
+
+	if size > maxAttachmentSizeAllowed {
+		_ = dst.Abort()
+		return 0, fmt.Errorf("%w: attachment is too large, max: %d", errInvalidAttachment, maxAttachmentSizeAllowed)
+	}
+
+	if err = dst.Close(); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// удаляет из хранилища объекты вложений сообщения, например, если сообщение не удалось сохранить
+func deleteAttachments(ctx context.Context, s blob.Store, m *model.Message) {
+	for _, a := range m.Attachments {
+		if err := s.Delete(a.ID.String()); err != nil {
+			slog.ErrorContext(ctx, "failed to delete attachment", "attachment_id", a.ID, "message_id", m.ID, "error", err)
+		}
+	}
+}
+
+// расшифровывает имена вложений для отображения на странице расшифрованного сообщения
+func attachmentsForTemplate(m *model.Message, key []byte) ([]map[string]interface{}, error) {
+	attachments := make([]map[string]interface{}, len(m.Attachments))
+	for i, a := range m.Attachments {
+		name, err := crypt.Decrypt(a.EncryptedName, key, a.AssociatedData())
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting attachment name, error: %v", err)
+		}
+
+		attachments[i] = map[string]interface{}{
+			attachmentIDKey:   a.ID,
+			attachmentNameKey: string(name),
+			attachmentSizeKey: a.Size,
+		}
+	}
+	return attachments, nil
+}
+
+// uploads вложения, которые еще не прочитаны из multipart-тела запроса
+type uploads struct {
+	reader *multipart.Reader
+	next   *multipart.Part
+}
+
+// nextPart возвращает следующую часть тела или nil, если частей больше нет
+func (u *uploads) nextPart() (*multipart.Part, error) {
+	if u.next != nil {
+		part := u.next
+		u.next = nil
+		return part, nil
+	}
+	if u.reader == nil {
+		return nil, nil
+	}
+
+	part, err := u.reader.NextPart()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment, error: %w", err)
+	}
+	return part, nil
+}
+
+// readFormFields читает поля формы до первого вложения и заполняет r.Form и r.PostForm. Вложения,
+// которые в форме идут последними, остаются в теле запроса, чтобы storeAttachments шифровал их потоком
+func readFormFields(r *http.Request) (*uploads, error) {
+	mr, err := r.MultipartReader()
+	if errors.Is(err, http.ErrNotMultipart) {
+		if err = r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("error parsing form data, error: %w", err)
+		}
+		return &uploads{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing form data, error: %w", err)
+	}
+
+	postForm := make(url.Values)
+	remaining := int64(maxFormFieldsSize)
+	u := &uploads{reader: mr}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing form data, error: %w", err)
+		}
+
+		if part.FormName() == attachmentsKey {
+			u.next = part
+			break
+		}
+		if part.FileName() != "" {
+			return nil, fmt.Errorf("%w: unexpected file in form field %s", errInvalidAttachment, part.FormName())
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing form data, error: %w", err)
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, fmt.Errorf("form fields are too large, max: %d", maxFormFieldsSize)
+		}
+		postForm.Add(part.FormName(), string(value))
+	}
+
+	r.PostForm = postForm
+	r.Form = make(url.Values)
+	for key, values := range postForm {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	for key, values := range r.URL.Query() {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+
+	return u, nil
+}
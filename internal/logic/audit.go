@@ -0,0 +1,153 @@
+package logic
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/model"
+)
+
+// auditSecret ключ HMAC цепочки журнала, задается при настройке роутера через SetAuditSecret
+var auditSecret []byte
+
+// SetAuditSecret задает ключ, которым вычисляются и проверяются хеши записей журнала
+func SetAuditSecret(secret string) {
+	auditSecret = []byte(secret)
+}
+
+// запись журнала в выгрузке
+type auditEventExport struct {
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// выгрузка цепочки журнала сообщения с результатом ее проверки
+type auditExport struct {
+	MessageID uuid.UUID          `json:"message_id"`
+	Valid     bool               `json:"valid"`
+	BrokenAt  int64              `json:"broken_at,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Events    []auditEventExport `json:"events"`
+}
+
+// выгружает журнал событий сообщения в JSON и проверяет цепочку хешей
+func AuditHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	messageID := parts[len(parts)-1]
+
+	parsedUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	events, err := db.GetAuditEvents(ctx, p, &parsedUUID)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+	if len(events) == 0 {
+		logErrorAndRespond(w, r, fmt.Sprintf("no audit events for message %v", parsedUUID), http.StatusNotFound)
+		return
+	}
+
+	export := auditExport{
+		MessageID: parsedUUID,
+		Valid:     true,
+		Events:    make([]auditEventExport, len(events)),
+	}
+
+	if brokenAt, err := model.VerifyAuditChain(events, auditSecret); err != nil {
+		export.Valid = false
+		export.BrokenAt = brokenAt
+		export.Error = err.Error()
+	}
+
+	for i, e := range events {
+		details := json.RawMessage(e.Details)
+		if !json.Valid(details) {
+			// измененная запись может содержать что угодно, выгружаем ее как строку
+			details, _ = json.Marshal(e.Details)
+		}
+
+		export.Events[i] = auditEventExport{
+			Seq:       e.Seq,
+			Type:      e.Type,
+			Details:   details,
+			CreatedAt: e.CreatedAt.UTC(),
+			PrevHash:  hex.EncodeToString(e.PrevHash[:]),
+			Hash:      hex.EncodeToString(e.Hash[:]),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(export); err != nil {
+		slog.ErrorContext(ctx, "error writing audit response", "error", err)
+	}
+}
+
+// добавляет событие в журнал сообщения; ошибка записи журнала не прерывает обработку запроса.
+// События, без которых журнал неполон, записываются через newAudit в транзакции изменения состояния
+// или через recordRequiredEvent до выдачи данных
+func recordEvent(ctx context.Context, p *pgxpool.Pool, mid uuid.UUID, eventType string, details map[string]string) {
+	if err := recordRequiredEvent(ctx, p, mid, eventType, details); err != nil {
+		slog.ErrorContext(ctx, "failed to record audit event", "type", eventType, "message_id", mid, "error", err)
+	}
+}
+
+// добавляет событие в журнал сообщения и возвращает ошибку записи, чтобы запрос можно было отклонить
+func recordRequiredEvent(ctx context.Context, p *pgxpool.Pool, mid uuid.UUID, eventType string, details map[string]string) error {
+	// событие записывается и после отключения клиента
+	ctx = context.WithoutCancel(ctx)
+
+	e, err := model.NewAuditEvent(mid, eventType, details)
+	if err != nil {
+		return err
+	}
+
+	if err = db.AppendAuditEvent(ctx, p, e, auditSecret); err != nil {
+		return fmt.Errorf("failed to record %s audit event, error: %w", eventType, err)
+	}
+
+	logAuditEvents(ctx, e)
+	return nil
+}
+
+// готовит событие журнала, которое сохраняется в одной транзакции с изменением состояния
+func newAudit(mid uuid.UUID, eventType string, details map[string]string) (*db.Audit, error) {
+	e, err := model.NewAuditEvent(mid, eventType, details)
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.Audit{Events: []*model.AuditEvent{e}, Secret: auditSecret}, nil
+}
+
+// пишет сохраненные события в журнал приложения. Хеш последней записи попадает в журнал приложения, вне базы данных,
+// чтобы по нему можно было обнаружить удаление записей из конца цепочки
+func logAuditEvents(ctx context.Context, events ...*model.AuditEvent) {
+	for _, e := range events {
+		slog.InfoContext(ctx, "audit event recorded", "type", e.Type, "message_id", e.MessageID, "seq", e.Seq, "hash", hex.EncodeToString(e.Hash[:]))
+	}
+}
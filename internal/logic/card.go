@@ -0,0 +1,34 @@
+package logic
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/mclyashko/everlock/internal/card"
+)
+
+const (
+	cardNameKey = "cardName"
+	cardSVGKey  = "cardSVG"
+	cardPDFKey  = "cardPDF"
+)
+
+// добавляет к части ключа печатные карточки SVG и PDF в виде data URI: части ключа не хранятся на сервере,
+// поэтому карточки можно скачать только со страницы успеха
+func addCards(key map[string]interface{}, c *card.Card, name string) error {
+	svg, err := card.SVG(c)
+	if err != nil {
+		return fmt.Errorf("failed to render SVG card, error: %v", err)
+	}
+
+	pdf, err := card.PDF(c)
+	if err != nil {
+		return fmt.Errorf("failed to render PDF card, error: %v", err)
+	}
+
+	key[cardNameKey] = name
+	key[cardSVGKey] = "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg)
+	key[cardPDFKey] = "data:application/pdf;base64," + base64.StdEncoding.EncodeToString(pdf)
+
+	return nil
+}
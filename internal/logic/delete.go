@@ -0,0 +1,38 @@
+package logic
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/blob"
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/model"
+)
+
+// DeleteMessage удаляет сообщение и его вложения. Событие удаления сохраняется в журнале в одной транзакции
+// с удалением сообщения, сам журнал остается. Объекты вложений удаляются из хранилища после удаления сообщения
+func DeleteMessage(ctx context.Context, p *pgxpool.Pool, s blob.Store, mid uuid.UUID) error {
+	message, err := db.GetMessageByID(ctx, p, &mid)
+	if err != nil {
+		return err
+	}
+
+	audit, err := newAudit(message.ID, model.AuditMessageDeleted, map[string]string{
+		"attachments": strconv.Itoa(len(message.Attachments)),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = db.DeleteMessage(ctx, p, &message.ID, audit); err != nil {
+		return err
+	}
+
+	logAuditEvents(ctx, audit.Events...)
+	deleteAttachments(ctx, s, message)
+
+	return nil
+}
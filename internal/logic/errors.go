@@ -0,0 +1,137 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/pow"
+)
+
+const (
+	// statusClientClosedRequest код ответа nginx для запроса, который клиент отменил до получения ответа
+	statusClientClosedRequest = 499
+	// pgQueryCanceledCode код ошибки PostgreSQL при отмене запроса по statement_timeout
+	pgQueryCanceledCode = "57014"
+
+	statusCodeKey    = "statusCode"
+	statusTextKey    = "statusText"
+	errorMessageKey  = "errorMessage"
+	errorTemplate    = "error.html"
+	jsonContentType  = "application/json"
+	defaultErrorText = "Something went wrong on our side. Please try again later."
+)
+
+var (
+	// errKeysFull все части ключа группы уже введены
+	errKeysFull = errors.New("all keys of the group are already entered")
+	// errInvalidShare часть ключа не разобрана или не относится к сообщению
+	errInvalidShare = errors.New("invalid share")
+	// errExpired срок действия истек
+	errExpired = errors.New("expired")
+	// errInvalidAttachment вложение не прошло проверку количества, размера или имени
+	errInvalidAttachment = errors.New("invalid attachment")
+)
+
+// понятные пользователю описания ошибок; внутренние подробности в ответ не попадают
+var errorTexts = map[int]string{
+	http.StatusBadRequest:            "The request is invalid. Please check the entered data and try again.",
+	http.StatusForbidden:             "This action is not allowed.",
+	http.StatusNotFound:              "Nothing was found at this link. Please check that it was copied completely.",
+	http.StatusMethodNotAllowed:      "This action is not supported by the page.",
+	http.StatusConflict:              "The request conflicts with the current state, for example, all keys have already been entered.",
+	http.StatusGone:                  "The link or code has expired.",
+	http.StatusRequestEntityTooLarge: "The request is too large. Please check the size of the message and attachments.",
+	http.StatusUnprocessableEntity:   "The key share could not be accepted. Please check that it was copied completely and belongs to this message.",
+	http.StatusLocked:                "Access to this message is temporarily locked for you after repeated failed attempts. Please try again later.",
+	http.StatusServiceUnavailable:    "The service is temporarily unavailable. Please try again later.",
+	http.StatusGatewayTimeout:        "The request took too long. Please try again later.",
+}
+
+// тело ответа с ошибкой в формате JSON
+type errorBody struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// errorStatus возвращает код ответа для ошибки: типизированные ошибки db и logic отображаются на 400, 404, 409, 410 и 422,
+// слишком большое тело запроса на 413, отмена запроса клиентом и истекший срок выполнения не считаются внутренней ошибкой сервера.
+// Для остальных ошибок возвращается fallback
+func errorStatus(err error, fallback int) int {
+	var pgErr *pgconn.PgError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrConflict), errors.Is(err, errKeysFull):
+		return http.StatusConflict
+	case errors.Is(err, errExpired), errors.Is(err, pow.ErrExpiredChallenge):
+		return http.StatusGone
+	case errors.Is(err, errInvalidShare):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errInvalidAttachment):
+		return http.StatusBadRequest
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &pgErr) && pgErr.Code == pgQueryCanceledCode:
+		return http.StatusGatewayTimeout
+	}
+	return fallback
+}
+
+// пишет ошибку в журнал с контекстом запроса и отвечает клиенту кодом statusCode. Ошибки клиента
+// пишутся с уровнем WARN, ошибки сервера с уровнем ERROR
+func logErrorAndRespond(w http.ResponseWriter, r *http.Request, errorMessage string, statusCode int) {
+	level := slog.LevelError
+	if statusCode < http.StatusInternalServerError {
+		level = slog.LevelWarn
+	}
+	slog.Log(r.Context(), level, errorMessage, "status", statusCode, "path", r.URL.Path)
+	respondError(w, r, statusCode)
+}
+
+// отвечает страницей ошибки или, если клиент ожидает JSON, телом errorBody
+func respondError(w http.ResponseWriter, r *http.Request, statusCode int) {
+	statusText := http.StatusText(statusCode)
+	if statusText == "" {
+		statusText = "Error"
+	}
+	errorText, ok := errorTexts[statusCode]
+	if !ok {
+		errorText = defaultErrorText
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), jsonContentType) {
+		writeJSON(w, statusCode, errorBody{Status: statusCode, Error: statusText, Message: errorText})
+		return
+	}
+
+	template, err := getTemplate(r.Context(), errorTemplate)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error loading error template", "error", err)
+		http.Error(w, errorText, statusCode)
+		return
+	}
+
+	renderedTemplate := template.Render(map[string]interface{}{
+		statusCodeKey:   statusCode,
+		statusTextKey:   statusText,
+		errorMessageKey: errorText,
+	})
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(statusCode)
+	if _, err = w.Write([]byte(renderedTemplate)); err != nil {
+		slog.ErrorContext(r.Context(), "error writing error page", "error", err)
+	}
+}
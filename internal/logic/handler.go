@@ -1,22 +1,30 @@
 package logic
 
 import (
-	"crypto/sha256"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/hashicorp/vault/shamir"
-	"github.com/hoisie/mustache"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/mclyashko/everlock/internal/blob"
+	"github.com/mclyashko/everlock/internal/config"
 	"github.com/mclyashko/everlock/internal/crypt"
+	"github.com/mclyashko/everlock/internal/csrf"
 	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/metrics"
 	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/notify"
+	"github.com/mclyashko/everlock/internal/pow"
+	"github.com/mclyashko/everlock/internal/ratelimit"
+	"github.com/mclyashko/everlock/internal/share"
+	"github.com/mclyashko/everlock/internal/tracing"
 )
 
 const (
@@ -25,7 +33,7 @@ const (
 	minKeyholdersAllowed      = 2
 	maxKeyholdersAllowed      = 256
 	aesKeySize                = 32
-	maxEncryptedMessageLength = 1024
+	maxEncryptedMessageLength = 1088
 	messageIDKey              = "messageID"
 	nicknameKey               = "nickname"
 	messageKey                = "message"
@@ -34,94 +42,205 @@ const (
 	minKeyholdersKey          = "minKeyholders"
 	keysKey                   = "keys"
 	keyKey                    = "key"
+	groupsKey                 = "groups"
+	groupThresholdKey         = "groupThreshold"
+	groupKey                  = "group"
+	groupIndexKey             = "groupIndex"
+	groupNameKey              = "groupName"
+	groupCompleteKey          = "groupComplete"
+	groupsCompleteKey         = "groupsComplete"
+	multipleGroupsKey         = "multipleGroups"
+	csrfTokenKey              = csrf.TokenField
 )
 
 // предоставляет доступ к шаблону главной страницы
-func MainPageHandler(w http.ResponseWriter, r *http.Request) {
+func MainPageHandler(pw *pow.Issuer, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		logErrorAndRespond(w, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
-	template, err := mustache.ParseFile(filepath.Join("..", "..", "internal", "template", "index.html"))
+	template, err := getTemplate(r.Context(), "index.html")
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error loading main page template, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("error loading main page template, error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	renderedTemplate := template.Render()
+	data := map[string]interface{}{
+		csrfTokenKey: csrf.Token(r),
+	}
+	if err = addChallenge(data, pw); err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderedTemplate := template.Render(data)
 	w.Header().Set("Content-Type", "text/html")
 	if _, err = w.Write([]byte(renderedTemplate)); err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error writing main page response, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("error writing main page response, error: %v", err), http.StatusInternalServerError)
 	}
 }
 
+// данные формы создания сообщения
+type messageForm struct {
+	nickname     string
+	messageText  string
+	policy       *model.Policy
+	releaseDelay time.Duration
+	attachments  *uploads
+	recipients   []string
+	signingKeys  [][]byte
+	// адреса уведомлений создателя и хранителей ключа
+	creatorNotifyURL string
+	notifyURLs       []string
+}
+
 // обрабабатывает форму добавления сообщения
-func SubmitMessageHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
+func SubmitMessageHandler(p *pgxpool.Pool, s blob.Store, cs crypt.Suite, ac *config.Abuse, pw *pow.Issuer, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	if r.Method != http.MethodPost {
-		logErrorAndRespond(w, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if status, err := checkCreationAllowed(p, ac, pw, r); err != nil {
+		logErrorAndRespond(w, r, err.Error(), status)
 		return
 	}
 
-	nickname, messageText, keyholders, minKeyholders, err := parseFormData(r)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSubmitRequestSize)
+
+	form, err := parseFormData(r)
 	if err != nil {
-		logErrorAndRespond(w, err.Error(), http.StatusBadRequest)
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	recipients, err := resolveRecipients(ctx, p, form.recipients)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusBadRequest))
 		return
 	}
 
 	aesKey, err := crypt.GenerateRandomAESKey(aesKeySize)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to generate encryption key, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to generate encryption key, error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	encryptedMessage, err := crypt.EncryptAES([]byte(messageText), aesKey)
+	_, span := tracing.Start(ctx, "crypto.split")
+	keyShares, err := splitByPolicy(aesKey, form.policy)
+	tracing.End(span, err)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to encrypt message, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, "failed to split secret key", http.StatusBadRequest)
 		return
 	}
-	if len(encryptedMessage) > maxEncryptedMessageLength {
-		logErrorAndRespond(w, fmt.Sprintf("encryptedMessage length is to large: %d, max : %d", len(encryptedMessage), maxEncryptedMessageLength), http.StatusBadRequest)
+
+	message := model.NewMessage(form.nickname, form.policy, form.releaseDelay)
+	assignSigningKeys(message, form.signingKeys)
+	message.NotifyURL = form.creatorNotifyURL
+	assignNotifyURLs(message, form.notifyURLs)
+
+	if err = commitKey(message, aesKey); err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	keyHash := sha256.Sum256(aesKey)
+	associatedData, err := message.AssociatedData()
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to build associated data, error: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	keyShares, err := shamir.Split(aesKey, keyholders, minKeyholders)
+	_, span = tracing.Start(ctx, "crypto.encrypt")
+	message.EncryptedContent, err = crypt.Encrypt(cs, []byte(form.messageText), aesKey, associatedData)
+	tracing.End(span, err)
 	if err != nil {
-		logErrorAndRespond(w, "failed to split secret key", http.StatusBadRequest)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to encrypt message, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	message.ContentVersion = crypt.CiphertextVersion2
+	if len(message.EncryptedContent) > maxEncryptedMessageLength {
+		logErrorAndRespond(w, r, fmt.Sprintf("encryptedMessage length is to large: %d, max : %d", len(message.EncryptedContent), maxEncryptedMessageLength), http.StatusBadRequest)
 		return
 	}
 
-	message := model.NewMessage(nickname, encryptedMessage, keyHash, keyholders, minKeyholders)
+	var creatorVetoCode string
+	var vetoCodes []string
+	if message.ReleaseDelay > 0 {
+		creatorVetoCode, vetoCodes, err = assignVetoCodes(message)
+		if err != nil {
+			logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	if err = db.SaveNewMessage(p, message); err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("transaction commit failed, error: %v", err), http.StatusInternalServerError)
+	// части ключа существуют только в памяти, поэтому шифруются для хранителей, превращаются в карточки
+	// и страница с ними отрисовывается до сохранения сообщения: при ошибке сообщение не сохраняется,
+	// иначе его уже нельзя было бы расшифровать
+	_, span = tracing.Start(ctx, "crypto.export_shares")
+	groups, err := sharesForTemplate(message, keyShares, vetoCodes, recipients)
+	tracing.End(span, err)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	template, err := mustache.ParseFile(filepath.Join("..", "..", "internal", "template", "message_success.html"))
+	template, err := getTemplate(ctx, "message_success.html")
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error loading success template, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("error loading success template, error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	renderedTemplate := template.Render(map[string]interface{}{
-		messageIDKey: message.ID,
-		keysKey:      keyShares,
+	data := map[string]interface{}{
+		messageIDKey:      message.ID,
+		groupsKey:         groups,
+		multipleGroupsKey: len(form.policy.Groups) > 1,
+	}
+	if creatorVetoCode != "" {
+		data[creatorVetoCodeKey] = creatorVetoCode
+		data[releaseDelayHoursKey] = int(message.ReleaseDelay / time.Hour)
+	}
+
+	renderedTemplate := template.Render(data)
+
+	_, span = tracing.Start(ctx, "crypto.encrypt_attachments")
+	err = storeAttachments(s, message, form.attachments, cs, aesKey)
+	tracing.End(span, err)
+	if err != nil {
+		deleteAttachments(ctx, s, message)
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	if err = db.SaveNewMessage(ctx, p, message); err != nil {
+		deleteAttachments(ctx, s, message)
+		logErrorAndRespond(w, r, fmt.Sprintf("transaction commit failed, error: %v", err), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	metrics.MessagesCreated.Inc()
+	recordEvent(ctx, p, message.ID, model.AuditMessageCreated, map[string]string{
+		"groups":        strconv.Itoa(len(message.Policy.Groups)),
+		"keyholders":    strconv.Itoa(len(message.Keys)),
+		"attachments":   strconv.Itoa(len(message.Attachments)),
+		"release_delay": message.ReleaseDelay.String(),
 	})
 
 	w.Header().Set("Content-Type", "text/html")
 	_, err = w.Write([]byte(renderedTemplate))
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
 	}
 }
 
 // предоставляет доступ к шаблону страницы статуса расшифровки
-func DecryptMessageHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
+func DecryptMessageHandler(p *pgxpool.Pool, n notify.Notifier, cs crypt.Suite, rl *config.RateLimit, ip *ratelimit.ClientIP, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	if r.Method != http.MethodGet {
-		logErrorAndRespond(w, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -130,103 +249,156 @@ func DecryptMessageHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Reque
 
 	parsedUUID, err := uuid.Parse(messageID)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	message, err := db.GetMessageByID(p, &parsedUUID)
+	message, err := db.GetMessageByID(ctx, p, &parsedUUID)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to get message, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to get message, error: %v", err), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	client := clientID(ip, r)
+	if respondIfLocked(w, r, p, message, client) {
 		return
 	}
 
 	var keysEntered int
-	var keys [][]byte
 	minKeyholders := message.MinKeyholders
 	totalKeyholders := len(message.Keys)
 	for _, key := range message.Keys {
 		if key.SecretPart != nil {
 			keysEntered++
-			keys = append(keys, key.SecretPart)
 		}
 	}
 
-	if keysEntered < minKeyholders {
-		template, err := mustache.ParseFile(filepath.Join("..", "..", "internal", "template", "decrypt.html"))
+	shares := collectShares(message)
+	groups, groupsComplete := policyProgress(shares, message.Policy)
+
+	if groupsComplete < message.Policy.Threshold {
+		template, err := getTemplate(ctx, "decrypt.html")
 		if err != nil {
-			logErrorAndRespond(w, fmt.Sprintf("error loading decrypt template, error: %v", err), http.StatusInternalServerError)
+			logErrorAndRespond(w, r, fmt.Sprintf("error loading decrypt template, error: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		renderedTemplate := template.Render(map[string]interface{}{
-			messageIDKey:     message.ID,
-			nicknameKey:      message.CreatorName,
-			keysEnteredKey:   keysEntered,
-			minKeyholdersKey: minKeyholders,
-			keyholdersKey:    totalKeyholders,
-		})
+		data := map[string]interface{}{
+			messageIDKey:      message.ID,
+			nicknameKey:       message.CreatorName,
+			keysEnteredKey:    keysEntered,
+			minKeyholdersKey:  minKeyholders,
+			keyholdersKey:     totalKeyholders,
+			groupsKey:         groups,
+			groupsCompleteKey: groupsComplete,
+			groupThresholdKey: message.Policy.Threshold,
+			multipleGroupsKey: len(message.Policy.Groups) > 1,
+			csrfTokenKey:      csrf.Token(r),
+		}
+		if message.ReleaseDelay > 0 {
+			data[releaseDelayHoursKey] = int(message.ReleaseDelay / time.Hour)
+		}
+		if message.VetoedAt != nil {
+			data[vetoedAtKey] = message.VetoedAt.UTC().Format(time.RFC1123)
+		}
+
+		renderedTemplate := template.Render(data)
 
 		w.Header().Set("Content-Type", "text/html")
 		_, err = w.Write([]byte(renderedTemplate))
 		if err != nil {
-			logErrorAndRespond(w, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
+			logErrorAndRespond(w, r, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
 		}
 
 		return
 	}
 
-	combinedKey, err := shamir.Combine(keys)
+	combinedKey, err := recoverKey(ctx, p, rl, message, shares, client)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error combining keys, error: %v", err), http.StatusInternalServerError)
-		if cleanErr := db.CleanKeysByMsgID(p, &message.ID); cleanErr != nil {
-			log.Printf("failed to clean keys for message with id %v, error: %v", message.ID, cleanErr)
-		}
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
-	if actualHash := sha256.Sum256(combinedKey); actualHash != message.KeyHash {
-		logErrorAndRespond(w, fmt.Sprintf("key hashes are not equal, actual: %v, expected: %v", actualHash, message.KeyHash), http.StatusInternalServerError)
-		if cleanErr := db.CleanKeysByMsgID(p, &message.ID); cleanErr != nil {
-			log.Printf("failed to clean keys for message with id %v, error: %v", message.ID, cleanErr)
+	if message.ReleaseDelay > 0 {
+		releaseAt, released, err := releaseWindow(ctx, p, n, message)
+		if err != nil {
+			logErrorAndRespond(w, r, fmt.Sprintf("failed to check release window, error: %v", err), errorStatus(err, http.StatusInternalServerError))
+			return
 		}
+
+		if !released {
+			renderReleasePending(w, r, message, releaseAt)
+			return
+		}
+	}
+
+	associatedData, err := message.AssociatedData()
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to build associated data, error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	decryptedMessage, err := crypt.DecryptAES(
-		message.EncryptedContent,
-		combinedKey,
-	)
+	_, span := tracing.Start(ctx, "crypto.decrypt")
+	decryptedMessage, err := decryptContent(message, combinedKey, associatedData)
+	tracing.End(span, err)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error decrypring message: %v", err), http.StatusInternalServerError)
-		if cleanErr := db.CleanKeysByMsgID(p, &message.ID); cleanErr != nil {
-			log.Printf("failed to clean keys for message with id %v, error: %v", message.ID, cleanErr)
-		}
+		logErrorAndRespond(w, r, fmt.Sprintf("error decrypring message: %v", err), http.StatusInternalServerError)
+		metrics.Reconstructions.WithLabelValues(metrics.ReconstructionDecryptError).Inc()
+		recordEvent(ctx, p, message.ID, model.AuditCombineFailed, map[string]string{"reason": "decryption failed"})
+		cleanKeys(ctx, p, message, "decryption failed")
+		recordFailedCombine(ctx, p, rl, message, client)
+		return
+	}
+
+	// расшифровка без записи в журнале не выдается
+	if err = recordRequiredEvent(ctx, p, message.ID, model.AuditMessageDecrypted, nil); err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics.Reconstructions.WithLabelValues(metrics.ReconstructionSuccess).Inc()
+	resetFailedCombines(ctx, p, message)
+
+	if message.HasLegacyContent() {
+		upgradeCiphertext(ctx, p, cs, message, combinedKey, decryptedMessage, associatedData)
+	}
+
+	if message.HasLegacyKeyHash() {
+		upgradeKeyCommitment(ctx, p, message, combinedKey)
+	}
+
+	template, err := getTemplate(ctx, "decrypt_complete.html")
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("error loading decrypt complete template, error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	template, err := mustache.ParseFile(filepath.Join("..", "..", "internal", "template", "decrypt_complete.html"))
+	attachments, err := attachmentsForTemplate(message, combinedKey)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error loading decrypt complete template, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	renderedTemplate := template.Render(map[string]interface{}{
-		messageIDKey: message.ID,
-		nicknameKey:  message.CreatorName,
-		messageKey:   string(decryptedMessage),
+		messageIDKey:   message.ID,
+		nicknameKey:    message.CreatorName,
+		messageKey:     string(decryptedMessage),
+		attachmentsKey: attachments,
 	})
 
 	w.Header().Set("Content-Type", "text/html")
 	_, err = w.Write([]byte(renderedTemplate))
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
 	}
 }
 
 // обрабабатывает форму добавления ключа
-func AddKeyHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
+func AddKeyHandler(p *pgxpool.Pool, ip *ratelimit.ClientIP, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	if r.Method != http.MethodPost {
-		logErrorAndRespond(w, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -235,93 +407,316 @@ func AddKeyHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
 
 	parsedUUID, err := uuid.Parse(messageID)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	message, err := db.GetMessageByID(p, &parsedUUID)
+	message, err := db.GetMessageByID(ctx, p, &parsedUUID)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to get message, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to get message, error: %v", err), errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
-	var emptyKey *model.MessageKey
+	client := clientID(ip, r)
+	if respondIfLocked(w, r, p, message, client) {
+		return
+	}
 
-	for _, key := range message.Keys {
-		if key.SecretPart == nil {
-			emptyKey = &key
+	input := r.FormValue(keyKey)
+	if strings.Contains(input, ageArmorHeader) || strings.Contains(input, pgpMessageHeader) {
+		logErrorAndRespond(w, r, "encrypted share submitted, it must be decrypted with the keyholder identity first", http.StatusBadRequest)
+		return
+	}
+
+	keyShare, err := share.Decode(input)
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse share, error: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if keyShare.MessageID != uuid.Nil && keyShare.MessageID != message.ID {
+		logErrorAndRespond(w, r, fmt.Sprintf("share of message %v submitted for message %v", keyShare.MessageID, message.ID), http.StatusUnprocessableEntity)
+		return
+	}
+
+	groupIndex := keyShare.GroupIndex
+	if groupIndex == share.NoGroup {
+		groupIndex, err = parseGroupIndex(r.FormValue(groupKey), message.Policy)
+		if err != nil {
+			logErrorAndRespond(w, r, err.Error(), http.StatusBadRequest)
+			return
 		}
+	} else if groupIndex >= len(message.Policy.Groups) {
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid share group: %d", groupIndex), http.StatusUnprocessableEntity)
+		return
+	}
+
+	signature, err := parseSignature(r.FormValue(signatureKey))
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	emptyKey, err := selectKeySlot(message, groupIndex, keyShare.Data, signature)
+	if errors.Is(err, errSignatureRequired) || errors.Is(err, errInvalidSignature) {
+		logErrorAndRespond(w, r, fmt.Sprintf("rejected share for message %v, error: %v", message.ID, err), http.StatusForbidden)
+		return
 	}
-	if emptyKey == nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to find empty key for message: %v", message), http.StatusInternalServerError)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
-	emptyKey.SecretPart, err = parseByteArray(r.FormValue(keyKey))
+	emptyKey.SecretPart = keyShare.Data
+	emptyKey.SubmittedBy = client
+
+	audit, err := newAudit(message.ID, model.AuditShareSubmitted, map[string]string{
+		"key_id": emptyKey.ID.String(),
+		"group":  strconv.Itoa(emptyKey.GroupIndex),
+		"signed": strconv.FormatBool(emptyKey.Signature != nil),
+	})
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to parse byte array, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = db.UpdateKeySecret(p, emptyKey)
+	err = db.UpdateKeySecret(ctx, p, emptyKey, audit)
 	if err != nil {
-		logErrorAndRespond(w, fmt.Sprintf("failed to add new key, error: %v", err), http.StatusInternalServerError)
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to add new key, error: %v", err), errorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
+	metrics.SharesSubmitted.Inc()
+	logAuditEvents(ctx, audit.Events...)
+
 	http.Redirect(w, r, fmt.Sprintf("/decrypt/%s", messageID), http.StatusSeeOther)
 }
 
-func logErrorAndRespond(w http.ResponseWriter, errorMessage string, statusCode int) {
-	log.Printf("%s", errorMessage)
-	http.Error(w, "Internal Server Error", statusCode)
+// восстанавливает ключ сообщения из введенных частей и сверяет его хеш;
+// при неудаче удаляет введенные части, чтобы хранители могли ввести их заново
+func recoverKey(ctx context.Context, p *pgxpool.Pool, rl *config.RateLimit, m *model.Message, shares [][][]byte, client string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "crypto.combine")
+	combinedKey, err := combineByPolicy(shares, m.Policy)
+	tracing.End(span, err)
+	if err != nil {
+		metrics.Reconstructions.WithLabelValues(metrics.ReconstructionCombineError).Inc()
+		recordEvent(ctx, p, m.ID, model.AuditCombineFailed, map[string]string{"reason": "combine failed"})
+		cleanKeys(ctx, p, m, "combine failed")
+		recordFailedCombine(ctx, p, rl, m, client)
+		return nil, fmt.Errorf("%w: error combining keys, error: %v", errInvalidShare, err)
+	}
+
+	if !verifyKey(m, combinedKey) {
+		metrics.Reconstructions.WithLabelValues(metrics.ReconstructionHashMismatch).Inc()
+		recordEvent(ctx, p, m.ID, model.AuditCombineFailed, map[string]string{"reason": "key commitment mismatch"})
+		cleanKeys(ctx, p, m, "key commitment mismatch")
+		recordFailedCombine(ctx, p, rl, m, client)
+		return nil, fmt.Errorf("combined key does not match key commitment of message %v: %w", m.ID, errInvalidShare)
+	}
+
+	return combinedKey, nil
+}
+
+// проверяет ключ по обязательству сообщения или по несоленому хешу у старых записей
+func verifyKey(m *model.Message, key []byte) bool {
+	if m.HasLegacyKeyHash() {
+		return crypt.VerifyLegacyKeyHash(key, m.KeyCommitment)
+	}
+	return crypt.VerifyKeyCommitment(key, m.KeySalt, m.ID[:], m.KeyCommitment)
 }
 
-func parseFormData(r *http.Request) (string, string, int, int, error) {
-	if err := r.ParseForm(); err != nil {
-		return "", "", 0, 0, fmt.Errorf("error parsing form data")
+// вычисляет обязательство ключа с новой солью и сохраняет его в сообщении
+func commitKey(m *model.Message, key []byte) error {
+	salt, err := crypt.GenerateRandomBytes(crypt.KeySaltSize)
+	if err != nil {
+		return fmt.Errorf("failed to generate key salt, error: %v", err)
+	}
+
+	commitment, err := crypt.KeyCommitment(key, salt, m.ID[:])
+	if err != nil {
+		return fmt.Errorf("failed to compute key commitment, error: %v", err)
+	}
+
+	m.KeySalt = salt
+	m.KeyCommitment = commitment
+
+	return nil
+}
+
+// заменяет несоленый хеш ключа старой записи обязательством ключа
+func upgradeKeyCommitment(ctx context.Context, p *pgxpool.Pool, m *model.Message, key []byte) {
+	if err := commitKey(m, key); err != nil {
+		slog.ErrorContext(ctx, "failed to upgrade key commitment", "message_id", m.ID, "error", err)
+		return
+	}
+
+	if err := db.UpdateKeyCommitment(ctx, p, &m.ID, m.KeySalt, m.KeyCommitment); err != nil {
+		slog.ErrorContext(ctx, "failed to upgrade key commitment", "message_id", m.ID, "error", err)
+	}
+}
+
+// расшифровывает содержимое сообщения в формате, записанном для него в базе данных. Старый формат без привязки
+// к метаданным допускается только для записей, отмеченных как созданные до его замены, поэтому подмена шифротекста
+// записью старого формата не обходит проверку метаданных
+func decryptContent(m *model.Message, key []byte, associatedData []byte) ([]byte, error) {
+	if m.HasLegacyContent() {
+		return crypt.DecryptAES(m.EncryptedContent, key)
+	}
+	return crypt.Decrypt(m.EncryptedContent, key, associatedData)
+}
+
+// перешифровывает сообщение, записанное в формате без привязки к метаданным, в текущий формат
+func upgradeCiphertext(ctx context.Context, p *pgxpool.Pool, cs crypt.Suite, m *model.Message, key []byte, plainData []byte, associatedData []byte) {
+	encryptedContent, err := crypt.Encrypt(cs, plainData, key, associatedData)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to re-encrypt message", "message_id", m.ID, "error", err)
+		return
+	}
+
+	if err = db.UpdateEncryptedContent(ctx, p, &m.ID, m.EncryptedContent, encryptedContent, crypt.CiphertextVersion2); err != nil {
+		slog.ErrorContext(ctx, "failed to upgrade ciphertext", "message_id", m.ID, "error", err)
+	}
+}
+
+func cleanKeys(ctx context.Context, p *pgxpool.Pool, m *model.Message, reason string) {
+	// стирание не прерывается отключением клиента, иначе части ключа можно перебирать, обрывая запрос до стирания
+	ctx = context.WithoutCancel(ctx)
+
+	audit, err := newAudit(m.ID, model.AuditKeysWiped, map[string]string{"reason": reason})
+	if err == nil {
+		err = db.CleanKeysByMsgID(ctx, p, &m.ID, audit)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to clean keys", "message_id", m.ID, "error", err)
+		return
+	}
+
+	metrics.KeyWipes.Inc()
+	logAuditEvents(ctx, audit.Events...)
+}
+
+// разбирает и проверяет поля формы создания сообщения и загруженные вложения
+func parseFormData(r *http.Request) (*messageForm, error) {
+	attachments, err := readFormFields(r)
+	if err != nil {
+		return nil, err
 	}
 
 	nickname := r.FormValue(nicknameKey)
 	messageText := r.FormValue(messageKey)
-	keyholders := r.FormValue(keyholdersKey)
-	minKeyholders := r.FormValue(minKeyholdersKey)
 
 	if len(nickname) > maxNicknameSizeAllowed {
-		return "", "", 0, 0, fmt.Errorf("nickname is too large: %s", nickname)
+		return nil, fmt.Errorf("nickname is too large: %s", nickname)
 	}
 
 	if len(messageText) > maxMessageSizeAllowed {
-		return "", "", 0, 0, fmt.Errorf("message is too large, message size: %d", len(messageText))
+		return nil, fmt.Errorf("message is too large, message size: %d", len(messageText))
+	}
+
+	policy, err := parsePolicy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseDelay, err := parseReleaseDelay(r.FormValue(releaseDelayHoursKey))
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := parseRecipients(r.FormValue(recipientsKey), policy.TotalMembers())
+	if err != nil {
+		return nil, err
 	}
 
-	keyholdersInt, err := strconv.Atoi(keyholders)
-	if err != nil || keyholdersInt < minKeyholdersAllowed || keyholdersInt > maxKeyholdersAllowed {
-		return "", "", 0, 0, fmt.Errorf("invalid number of keyholders: %s, error: %v", keyholders, err)
+	signingKeys, err := parseSigningKeys(r.FormValue(signingKeysKey), policy.TotalMembers())
+	if err != nil {
+		return nil, err
 	}
 
-	minKeyholdersInt, err := strconv.Atoi(minKeyholders)
-	if err != nil || minKeyholdersInt < minKeyholdersAllowed || minKeyholdersInt > maxKeyholdersAllowed || minKeyholdersInt > keyholdersInt {
-		return "", "", 0, 0, fmt.Errorf("invalid minimum keyholders: %s, error: %v", minKeyholders, err)
+	creatorNotifyURL, err := parseNotifyURL(r.FormValue(creatorNotifyURLKey))
+	if err != nil {
+		return nil, err
 	}
 
-	return nickname, messageText, keyholdersInt, minKeyholdersInt, nil
+	notifyURLs, err := parseNotifyURLs(r.FormValue(notifyURLsKey), policy.TotalMembers())
+	if err != nil {
+		return nil, err
+	}
+
+	return &messageForm{
+		nickname:     nickname,
+		messageText:  messageText,
+		policy:       policy,
+		releaseDelay: releaseDelay,
+		attachments:  attachments,
+		recipients:   recipients,
+		signingKeys:  signingKeys,
+
+		creatorNotifyURL: creatorNotifyURL,
+		notifyURLs:       notifyURLs,
+	}, nil
 }
 
-func parseByteArray(input string) ([]byte, error) {
-	trimmed := strings.Trim(input, "[]")
+// разбирает пороговую политику: группы хранителей, если они заданы, иначе простую схему "minKeyholders из keyholders"
+func parsePolicy(r *http.Request) (*model.Policy, error) {
+	groups, err := parseGroups(r.FormValue(groupsKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		keyholders := r.FormValue(keyholdersKey)
+		minKeyholders := r.FormValue(minKeyholdersKey)
 
-	parts := strings.Fields(trimmed)
+		keyholdersInt, err := strconv.Atoi(keyholders)
+		if err != nil || keyholdersInt < minKeyholdersAllowed || keyholdersInt > maxKeyholdersAllowed {
+			return nil, fmt.Errorf("invalid number of keyholders: %s, error: %v", keyholders, err)
+		}
 
-	bytes := make([]byte, len(parts))
-	for i, part := range parts {
-		var num int
-		_, err := fmt.Sscanf(part, "%d", &num)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse byte: %v", err)
+		minKeyholdersInt, err := strconv.Atoi(minKeyholders)
+		if err != nil || minKeyholdersInt < minKeyholdersAllowed || minKeyholdersInt > maxKeyholdersAllowed || minKeyholdersInt > keyholdersInt {
+			return nil, fmt.Errorf("invalid minimum keyholders: %s, error: %v", minKeyholders, err)
 		}
-		bytes[i] = byte(num)
+
+		return model.NewSingleGroupPolicy(keyholdersInt, minKeyholdersInt), nil
+	}
+
+	groupThreshold := r.FormValue(groupThresholdKey)
+	groupThresholdInt, err := strconv.Atoi(groupThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group threshold: %s, error: %v", groupThreshold, err)
+	}
+
+	policy := &model.Policy{
+		Threshold: groupThresholdInt,
+		Groups:    groups,
+	}
+
+	if err = policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy, error: %v", err)
+	}
+
+	if total := policy.TotalMembers(); total > maxKeyholdersAllowed {
+		return nil, fmt.Errorf("too many keyholders: %d, max: %d", total, maxKeyholdersAllowed)
+	}
+
+	if minShares := policy.MinShares(); minShares < minKeyholdersAllowed {
+		return nil, fmt.Errorf("policy allows decryption with %d keys, min: %d", minShares, minKeyholdersAllowed)
+	}
+
+	return policy, nil
+}
+
+// разбирает индекс группы, к которой относится вводимая часть ключа
+func parseGroupIndex(input string, p *model.Policy) (int, error) {
+	if input == "" {
+		return 0, nil
+	}
+
+	groupIndex, err := strconv.Atoi(input)
+	if err != nil || groupIndex < 0 || groupIndex >= len(p.Groups) {
+		return 0, fmt.Errorf("invalid group: %s, error: %v", input, err)
 	}
 
-	return bytes, nil
+	return groupIndex, nil
 }
@@ -0,0 +1,115 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/db"
+)
+
+const (
+	readyCheckTimeout = 2 * time.Second
+	checkOK           = "ok"
+	checkFailed       = "failed"
+)
+
+// шаблоны, без которых сервер не может обслуживать запросы
+var templateNames = []string{
+	"index.html",
+	"message_success.html",
+	"decrypt.html",
+	"decrypt_pending.html",
+	"decrypt_complete.html",
+	"invite.html",
+	"error.html",
+}
+
+// ответ /readyz: общий статус и статус каждой проверки. Причины неудач только пишутся в журнал,
+// потому что /readyz доступен без аутентификации, а ошибки содержат адреса и подробности зависимостей
+type readyReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// сообщает, что процесс запущен и обслуживает запросы, не обращаясь к зависимостям
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": checkOK})
+}
+
+// проверяет, что реплика может обслуживать запросы: база данных доступна, схема не старше
+// встроенных миграций, шаблоны читаются. Во время остановки сервера ready сброшен и реплика
+// сообщает о неготовности, чтобы балансировщик перестал направлять на нее запросы
+func ReadyHandler(p *pgxpool.Pool, ready *atomic.Bool, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+
+	report := readyReport{Status: checkOK, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			slog.WarnContext(ctx, "readiness check failed", "check", name, "error", err)
+			report.Status = checkFailed
+			report.Checks[name] = checkFailed
+			return
+		}
+		report.Checks[name] = checkOK
+	}
+
+	if !ready.Load() {
+		check("server", fmt.Errorf("server is shutting down"))
+	}
+	check("database", db.Ping(ctx, p))
+	check("migrations", checkSchemaVersion(ctx, p))
+	check("templates", checkTemplates(ctx))
+
+	status := http.StatusOK
+	if report.Status != checkOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func checkSchemaVersion(ctx context.Context, p *pgxpool.Pool) error {
+	expected, err := db.ExpectedSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := db.GetSchemaVersion(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", version)
+	}
+	// более новая схема допустима: при выкладке миграции применяются до замены реплик
+	if version < expected {
+		return fmt.Errorf("schema version %d is older than expected %d", version, expected)
+	}
+
+	return nil
+}
+
+func checkTemplates(ctx context.Context) error {
+	for _, name := range templateNames {
+		if _, err := getTemplate(ctx, name); err != nil {
+			return fmt.Errorf("template %s is unavailable, error: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write json response", "error", err)
+	}
+}
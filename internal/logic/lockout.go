@@ -0,0 +1,93 @@
+package logic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/ratelimit"
+)
+
+// возвращает идентификатор клиента для учета неудачных восстановлений: хеш адреса, чтобы адреса не хранились в базе данных
+func clientID(ip *ratelimit.ClientIP, r *http.Request) string {
+	sum := sha256.Sum256([]byte(ip.Key(r)))
+	return hex.EncodeToString(sum[:])
+}
+
+// отвечает 423, если клиент заблокирован для сообщения после повторных неудачных восстановлений ключа
+func respondIfLocked(w http.ResponseWriter, r *http.Request, p *pgxpool.Pool, m *model.Message, client string) bool {
+	lockedUntil, err := db.GetLockedUntil(r.Context(), p, &m.ID, client)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
+		return true
+	}
+	if lockedUntil == nil {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(*lockedUntil).Seconds()))))
+	logErrorAndRespond(w, r, fmt.Sprintf("client is locked for message %v until %v", m.ID, lockedUntil.UTC()), http.StatusLocked)
+	return true
+}
+
+// учитывает неудачное восстановление ключа и блокирует после повторных неудач клиентов, которые ввели части ключа.
+// Блокируются они, а не сообщение, чтобы один клиент не мог заблокировать сообщение для остальных.
+// Если введенные части ни за кем не записаны, неудача учитывается для клиента client, запросившего расшифровку
+func recordFailedCombine(ctx context.Context, p *pgxpool.Pool, rl *config.RateLimit, m *model.Message, client string) {
+	if rl.LockoutFailures <= 0 {
+		return
+	}
+
+	// неудача учитывается и после отключения клиента, иначе блокировку можно обойти, обрывая запрос
+	ctx = context.WithoutCancel(ctx)
+
+	clients := submitters(m)
+	if len(clients) == 0 {
+		clients = []string{client}
+	}
+
+	lockedClients, lockedUntil, err := db.RecordFailedCombine(ctx, p, &m.ID, clients, rl.LockoutFailures, rl.LockoutDuration)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record failed combine", "message_id", m.ID, "error", err)
+		return
+	}
+
+	if lockedClients > 0 {
+		slog.WarnContext(ctx, "clients locked after failed combines", "message_id", m.ID, "clients", lockedClients, "locked_until", lockedUntil.UTC(), "failures", rl.LockoutFailures)
+		recordEvent(ctx, p, m.ID, model.AuditMessageLocked, map[string]string{
+			"clients":      strconv.Itoa(lockedClients),
+			"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// возвращает клиентов, которые ввели части ключа сообщения, без повторов
+func submitters(m *model.Message) []string {
+	seen := make(map[string]bool)
+	var clients []string
+	for _, key := range m.Keys {
+		if key.SecretPart != nil && key.SubmittedBy != "" && !seen[key.SubmittedBy] {
+			seen[key.SubmittedBy] = true
+			clients = append(clients, key.SubmittedBy)
+		}
+	}
+	return clients
+}
+
+// сбрасывает счетчики неудачных восстановлений после успешной расшифровки
+func resetFailedCombines(ctx context.Context, p *pgxpool.Pool, m *model.Message) {
+	if err := db.ResetFailedCombines(ctx, p, &m.ID); err != nil {
+		slog.ErrorContext(ctx, "failed to reset failed combines", "message_id", m.ID, "error", err)
+	}
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/notify"
+)
+
+const (
+	creatorNotifyURLKey = "creatorNotifyURL"
+	notifyURLsKey       = "notifyURLs"
+	maxNotifyURLSize    = 2048
+	noNotifyURL         = "-"
+)
+
+// разбирает адрес webhook для уведомлений; пустая строка или "-" означают, что адрес не задан
+func parseNotifyURL(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" || input == noNotifyURL {
+		return "", nil
+	}
+	if len(input) > maxNotifyURLSize {
+		return "", fmt.Errorf("notification url is too large, size: %d", len(input))
+	}
+
+	u, err := url.Parse(input)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		return "", fmt.Errorf("invalid notification url: %s, error: %v", input, err)
+	}
+
+	return u.String(), nil
+}
+
+// разбирает адреса уведомлений хранителей: по одному на строку в порядке групп,
+// "-" или пустая строка означают, что хранитель не получает уведомлений
+func parseNotifyURLs(input string, total int) ([]string, error) {
+	input = strings.TrimRight(input, "\r\n\t ")
+	if input == "" {
+		return nil, nil
+	}
+
+	lines := strings.Split(input, "\n")
+	if len(lines) > total {
+		return nil, fmt.Errorf("too many notification urls: %d, keyholders: %d", len(lines), total)
+	}
+
+	urls := make([]string, len(lines))
+	for i, line := range lines {
+		notifyURL, err := parseNotifyURL(line)
+		if err != nil {
+			return nil, fmt.Errorf("keyholder %d: %v", i+1, err)
+		}
+		urls[i] = notifyURL
+	}
+
+	return urls, nil
+}
+
+// привязывает адреса уведомлений к частям ключа сообщения в порядке групп
+func assignNotifyURLs(m *model.Message, urls []string) {
+	for i := range urls {
+		m.Keys[i].NotifyURL = urls[i]
+	}
+}
+
+// возвращает адреса уведомлений создателя и хранителей ключа сообщения
+func notifyTargets(m *model.Message) []notify.Target {
+	var targets []notify.Target
+	if m.NotifyURL != "" {
+		targets = append(targets, notify.Target{Role: notify.RoleCreator, URL: m.NotifyURL})
+	}
+	for _, key := range m.Keys {
+		if key.NotifyURL != "" {
+			targets = append(targets, notify.Target{Role: notify.RoleKeyholder, URL: key.NotifyURL})
+		}
+	}
+	return targets
+}
+
+// ставит в очередь уведомления о событии для общего канала, создателя и хранителей ключа сообщения;
+// вызывается после сохранения изменения, о котором уведомляет
+func notifyEvent(ctx context.Context, n notify.Notifier, m *model.Message, e notify.Event) {
+	if err := n.Notify(e, notifyTargets(m)); err != nil {
+		slog.ErrorContext(ctx, "failed to queue notification", "type", e.Type, "message_id", e.MessageID, "error", err)
+	}
+}
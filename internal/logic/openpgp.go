@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"github.com/mclyashko/everlock/internal/share"
+)
+
+const pgpMessageType = "PGP MESSAGE"
+
+// получатель с открытым ключом OpenPGP, например, экспортированным из GnuPG через gpg --armor --export
+type openPGPRecipient struct {
+	entity *openpgp.Entity
+}
+
+func parseOpenPGPRecipient(publicKey string) (*openPGPRecipient, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenPGP public key, error: %v", err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("expected one OpenPGP public key, got: %d", len(entities))
+	}
+	if entities[0].PrivateKey != nil {
+		return nil, fmt.Errorf("OpenPGP private key submitted instead of public key")
+	}
+	if _, ok := entities[0].EncryptionKey(time.Now()); !ok {
+		return nil, fmt.Errorf("OpenPGP public key %X has no valid encryption key", entities[0].PrimaryKey.Fingerprint)
+	}
+
+	return &openPGPRecipient{entity: entities[0]}, nil
+}
+
+// шифрует закодированную часть ключа открытым ключом хранителя в сообщение OpenPGP в текстовом формате,
+// которое можно отправить по почте или распечатать и расшифровать через gpg --decrypt
+func (r *openPGPRecipient) seal(s *share.Share) (string, error) {
+	var buf bytes.Buffer
+
+	armorWriter, err := armor.Encode(&buf, pgpMessageType, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+
+	encryptWriter, err := openpgp.EncryptText(armorWriter, []*openpgp.Entity{r.entity}, nil, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+
+	if _, err = io.WriteString(encryptWriter, share.Encode(s)); err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+	if err = encryptWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+	if err = armorWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+	buf.WriteString("\n")
+
+	return buf.String(), nil
+}
+
+// описывает получателя отпечатком и первым идентификатором ключа
+func (r *openPGPRecipient) String() string {
+	fingerprint := fmt.Sprintf("%X", r.entity.PrimaryKey.Fingerprint)
+	if identity := r.entity.PrimaryIdentity(); identity != nil {
+		return fmt.Sprintf("%s (%s)", identity.Name, fingerprint)
+	}
+	return fingerprint
+}
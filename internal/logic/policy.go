@@ -0,0 +1,194 @@
+package logic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mclyashko/everlock/internal/card"
+	"github.com/mclyashko/everlock/internal/crypt"
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/share"
+)
+
+const (
+	maxGroupsAllowed        = 16
+	maxGroupNameSizeAllowed = 32
+	groupFieldsCount        = 3
+)
+
+// делит ключ на секреты групп, а каждый секрет группы - на части участников
+func splitByPolicy(key []byte, p *model.Policy) ([][][]byte, error) {
+	groupSecrets, err := crypt.SplitSecret(key, len(p.Groups), p.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split key into group secrets, error: %v", err)
+	}
+
+	shares := make([][][]byte, len(p.Groups))
+	for i, g := range p.Groups {
+		shares[i], err = crypt.SplitSecret(groupSecrets[i], g.Members, g.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split secret of group %d, error: %v", i, err)
+		}
+	}
+
+	return shares, nil
+}
+
+// восстанавливает ключ из частей, сгруппированных по индексу группы
+func combineByPolicy(shares [][][]byte, p *model.Policy) ([]byte, error) {
+	var groupSecrets [][]byte
+	for i, g := range p.Groups {
+		if len(shares[i]) < g.Threshold {
+			continue
+		}
+
+		groupSecret, err := crypt.CombineSecret(shares[i], g.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("failed to combine secret of group %d, error: %v", i, err)
+		}
+		groupSecrets = append(groupSecrets, groupSecret)
+	}
+
+	return crypt.CombineSecret(groupSecrets, p.Threshold)
+}
+
+// группирует введенные части ключа сообщения по группам политики
+func collectShares(m *model.Message) [][][]byte {
+	shares := make([][][]byte, len(m.Policy.Groups))
+	for _, key := range m.Keys {
+		if key.SecretPart == nil || key.GroupIndex < 0 || key.GroupIndex >= len(shares) {
+			continue
+		}
+		shares[key.GroupIndex] = append(shares[key.GroupIndex], key.SecretPart)
+	}
+	return shares
+}
+
+// считает прогресс ввода частей ключа по группам и количество групп, набравших порог
+func policyProgress(shares [][][]byte, p *model.Policy) ([]map[string]interface{}, int) {
+	progress := make([]map[string]interface{}, len(p.Groups))
+	groupsComplete := 0
+	for i, g := range p.Groups {
+		complete := len(shares[i]) >= g.Threshold
+		if complete {
+			groupsComplete++
+		}
+
+		progress[i] = map[string]interface{}{
+			groupIndexKey:    i,
+			groupNameKey:     groupDisplayName(g, i),
+			keysEnteredKey:   len(shares[i]),
+			minKeyholdersKey: g.Threshold,
+			keyholdersKey:    g.Members,
+			groupCompleteKey: complete,
+		}
+	}
+	return progress, groupsComplete
+}
+
+// готовит части ключа для отображения на странице успеха
+// коды вето и получатели, если они заданы, следуют в том же порядке, что и части ключа всех групп;
+// части ключа хранителей с открытым ключом шифруются и не показываются в открытом виде
+// вместе с карточками для печати. Вызывается до сохранения сообщения, чтобы ошибка не оставила его без частей ключа
+func sharesForTemplate(m *model.Message, shares [][][]byte, vetoCodes []string, recipients []shareRecipient) ([]map[string]interface{}, error) {
+	multipleGroups := len(m.Policy.Groups) > 1
+	result := make([]map[string]interface{}, len(m.Policy.Groups))
+	keyIndex := 0
+	for i, g := range m.Policy.Groups {
+		keys := make([]map[string]interface{}, len(shares[i]))
+		for j, data := range shares[i] {
+			s := &share.Share{MessageID: m.ID, GroupIndex: i, Data: data}
+			c := &card.Card{
+				MessageID: m.ID,
+				Label:     fmt.Sprintf("Keyholder %d of %d", j+1, g.Members),
+				Share:     share.Encode(s),
+				QRPayload: share.EncodeURI(s),
+			}
+			if multipleGroups {
+				c.Group = groupDisplayName(g, i)
+			}
+			c.Signed = m.Keys[keyIndex].SigningKey != nil
+
+			keys[j] = map[string]interface{}{}
+			if keyIndex < len(recipients) && recipients[keyIndex] != nil {
+				sealed, err := recipients[keyIndex].seal(s)
+				if err != nil {
+					return nil, err
+				}
+				c.Label = recipients[keyIndex].String()
+				c.Share, c.QRPayload, c.Encrypted = sealed, sealed, true
+				keys[j][encryptedKeyKey] = true
+				keys[j][recipientKey] = c.Label
+			}
+			keys[j][keyKey] = c.Share
+			keys[j][signingKeyKey] = c.Signed
+			if keyIndex < len(vetoCodes) {
+				keys[j][vetoCodeKey] = vetoCodes[keyIndex]
+			}
+			if err := addCards(keys[j], c, fmt.Sprintf("everlock-%s-%d-%d", m.ID, i+1, j+1)); err != nil {
+				return nil, err
+			}
+			keyIndex++
+		}
+
+		result[i] = map[string]interface{}{
+			groupNameKey:     groupDisplayName(g, i),
+			minKeyholdersKey: g.Threshold,
+			keyholdersKey:    g.Members,
+			keysKey:          keys,
+		}
+	}
+	return result, nil
+}
+
+func groupDisplayName(g model.Group, index int) string {
+	if g.Name != "" {
+		return g.Name
+	}
+	return fmt.Sprintf("Group %d", index+1)
+}
+
+// разбирает описание групп: по одной группе на строку в формате "название, участники, порог"
+func parseGroups(input string) ([]model.Group, error) {
+	var groups []model.Group
+
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != groupFieldsCount {
+			return nil, fmt.Errorf("invalid group definition: %s", line)
+		}
+
+		name := strings.TrimSpace(fields[0])
+		if len(name) > maxGroupNameSizeAllowed {
+			return nil, fmt.Errorf("group name is too large: %s", name)
+		}
+
+		members, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number of group members: %s, error: %v", fields[1], err)
+		}
+
+		threshold, err := strconv.Atoi(strings.TrimSpace(fields[2]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid group threshold: %s, error: %v", fields[2], err)
+		}
+
+		groups = append(groups, model.Group{
+			Name:      name,
+			Members:   members,
+			Threshold: threshold,
+		})
+	}
+
+	if len(groups) > maxGroupsAllowed {
+		return nil, fmt.Errorf("too many groups: %d, max: %d", len(groups), maxGroupsAllowed)
+	}
+
+	return groups, nil
+}
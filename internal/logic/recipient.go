@@ -0,0 +1,288 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/csrf"
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/share"
+)
+
+const (
+	maxInviteLabelSizeAllowed = 64
+	maxPublicKeySizeAllowed   = 16 << 10
+	maxRecipientSizeAllowed   = 16 << 10
+	invitePrefix              = "invite:"
+	invitePath                = "/invite/"
+	noRecipient               = "-"
+	ageArmorHeader            = "-----BEGIN AGE ENCRYPTED FILE-----"
+	pgpMessageHeader          = "-----BEGIN PGP MESSAGE-----"
+	pgpPublicKeyHeader        = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	pgpPublicKeyFooter        = "-----END PGP PUBLIC KEY BLOCK-----"
+	recipientsKey             = "recipients"
+	recipientKey              = "recipient"
+	encryptedKeyKey           = "encryptedKey"
+	inviteIDKey               = "inviteID"
+	inviteLabelKey            = "inviteLabel"
+	publicKeyKey              = "publicKey"
+	registeredAtKey           = "registeredAt"
+	openPGPKey                = "openPGP"
+)
+
+// создает приглашение хранителя (POST /invite), показывает его (GET /invite/{id})
+// и регистрирует по нему открытый ключ age (POST /invite/{id})
+func InviteHandler(p *pgxpool.Pool, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idPart := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/invite"), "/")
+
+	if idPart == "" {
+		if r.Method != http.MethodPost {
+			logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+
+		label := strings.TrimSpace(r.FormValue(inviteLabelKey))
+		if label == "" || len(label) > maxInviteLabelSizeAllowed {
+			logErrorAndRespond(w, r, fmt.Sprintf("invalid invite label size: %d", len(label)), http.StatusBadRequest)
+			return
+		}
+
+		invite := model.NewInvite(label)
+		if err := db.SaveInvite(ctx, p, invite); err != nil {
+			logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
+			return
+		}
+
+		http.Redirect(w, r, invitePath+invite.ID.String(), http.StatusSeeOther)
+		return
+	}
+
+	inviteID, err := uuid.Parse(idPart)
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	invite, err := db.GetInviteByID(ctx, p, &inviteID)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusNotFound))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		publicKey := strings.TrimSpace(r.FormValue(publicKeyKey))
+		if len(publicKey) > maxPublicKeySizeAllowed {
+			logErrorAndRespond(w, r, fmt.Sprintf("public key is too large, size: %d", len(publicKey)), http.StatusBadRequest)
+			return
+		}
+		if _, err = parsePublicKey(publicKey); err != nil {
+			logErrorAndRespond(w, r, fmt.Sprintf("invalid public key, error: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		invite.PublicKey = publicKey
+		invite.KeyType = publicKeyType(publicKey)
+		err = db.RegisterInvitePublicKey(ctx, p, invite)
+		if errors.Is(err, db.ErrInviteAlreadyRegistered) {
+			logErrorAndRespond(w, r, fmt.Sprintf("invite %v is already registered", invite.ID), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusInternalServerError))
+			return
+		}
+	default:
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	template, err := getTemplate(ctx, "invite.html")
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("error loading invite template, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		inviteIDKey:    invite.ID,
+		inviteLabelKey: invite.Label,
+		csrfTokenKey:   csrf.Token(r),
+	}
+	if invite.IsRegistered() {
+		data[publicKeyKey] = invite.PublicKey
+		data[openPGPKey] = invite.KeyType == model.InviteKeyOpenPGP
+		data[registeredAtKey] = invite.RegisteredAt.UTC().Format(http.TimeFormat)
+	}
+
+	renderedTemplate := template.Render(data)
+
+	w.Header().Set("Content-Type", "text/html")
+	if _, err = w.Write([]byte(renderedTemplate)); err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// получатель части ключа, которому она выдается в зашифрованном виде
+type shareRecipient interface {
+	// seal шифрует закодированную часть ключа в текстовый формат, который хранитель расшифрует своим закрытым ключом
+	seal(s *share.Share) (string, error)
+	// String описывает получателя на странице успеха
+	String() string
+}
+
+// разбирает получателей частей ключа: по одному получателю на хранителя в порядке групп.
+// Получатель занимает одну строку с открытым ключом age, ссылкой на приглашение ("invite:<id>" или URL приглашения),
+// "-" или пустой строкой, если часть ключа выдается без шифрования, либо несколько строк
+// с открытым ключом OpenPGP в текстовом формате
+func parseRecipients(input string, total int) ([]string, error) {
+	input = strings.TrimRight(input, "\r\n\t ")
+	if input == "" {
+		return nil, nil
+	}
+
+	var recipients []string
+	var block []string
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+
+		if block != nil || line == pgpPublicKeyHeader {
+			block = append(block, line)
+			if line == pgpPublicKeyFooter {
+				recipients = append(recipients, strings.Join(block, "\n"))
+				block = nil
+			}
+			continue
+		}
+
+		if line == noRecipient {
+			line = ""
+		}
+		recipients = append(recipients, line)
+	}
+
+	if block != nil {
+		return nil, fmt.Errorf("OpenPGP public key of recipient %d is truncated", len(recipients)+1)
+	}
+
+	if len(recipients) > total {
+		return nil, fmt.Errorf("too many recipients: %d, keyholders: %d", len(recipients), total)
+	}
+
+	for i, recipient := range recipients {
+		if len(recipient) > maxRecipientSizeAllowed {
+			return nil, fmt.Errorf("recipient %d is too large, size: %d", i+1, len(recipient))
+		}
+	}
+
+	return recipients, nil
+}
+
+// находит открытые ключи получателей, подставляя ключи, зарегистрированные по приглашениям
+func resolveRecipients(ctx context.Context, p *pgxpool.Pool, recipients []string) ([]shareRecipient, error) {
+	resolved := make([]shareRecipient, len(recipients))
+	for i, recipient := range recipients {
+		if recipient == "" {
+			continue
+		}
+
+		publicKey := recipient
+		if inviteID, ok := parseInviteReference(recipient); ok {
+			invite, err := db.GetInviteByID(ctx, p, &inviteID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get invite of recipient %d, error: %w", i+1, err)
+			}
+			if !invite.IsRegistered() {
+				return nil, fmt.Errorf("invite %v of recipient %d is not registered yet", inviteID, i+1)
+			}
+			publicKey = invite.PublicKey
+		}
+
+		parsed, err := parsePublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key of recipient %d, error: %v", i+1, err)
+		}
+		resolved[i] = parsed
+	}
+
+	return resolved, nil
+}
+
+// разбирает открытый ключ age или открытый ключ OpenPGP в текстовом формате
+func parsePublicKey(publicKey string) (shareRecipient, error) {
+	if publicKeyType(publicKey) == model.InviteKeyOpenPGP {
+		return parseOpenPGPRecipient(publicKey)
+	}
+
+	recipient, err := age.ParseX25519Recipient(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return ageRecipient{recipient}, nil
+}
+
+func publicKeyType(publicKey string) string {
+	if strings.HasPrefix(publicKey, pgpPublicKeyHeader) {
+		return model.InviteKeyOpenPGP
+	}
+	return model.InviteKeyAge
+}
+
+func parseInviteReference(recipient string) (uuid.UUID, bool) {
+	var id string
+	switch {
+	case strings.HasPrefix(recipient, invitePrefix):
+		id = strings.TrimPrefix(recipient, invitePrefix)
+	case strings.Contains(recipient, invitePath):
+		id = recipient[strings.LastIndex(recipient, invitePath)+len(invitePath):]
+	default:
+		return uuid.Nil, false
+	}
+
+	inviteID, err := uuid.Parse(strings.TrimRight(id, "/"))
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return inviteID, true
+}
+
+// получатель с открытым ключом age (X25519)
+type ageRecipient struct {
+	*age.X25519Recipient
+}
+
+// шифрует закодированную часть ключа открытым ключом хранителя в текстовый формат age
+func (r ageRecipient) seal(s *share.Share) (string, error) {
+	var buf bytes.Buffer
+
+	armorWriter := armor.NewWriter(&buf)
+	encryptWriter, err := age.Encrypt(armorWriter, r.X25519Recipient)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+
+	if _, err = io.WriteString(encryptWriter, share.Encode(s)); err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+	if err = encryptWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+	if err = armorWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt share, error: %v", err)
+	}
+
+	return buf.String(), nil
+}
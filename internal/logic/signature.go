@@ -0,0 +1,117 @@
+package logic
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/share"
+)
+
+const (
+	signingKeysKey     = "signingKeys"
+	signatureKey       = "signature"
+	signingKeyKey      = "signingKey"
+	maxSignatureLength = 128
+)
+
+var (
+	errSignatureRequired = errors.New("signature is required for the remaining keys of the group")
+	errInvalidSignature  = errors.New("signature does not match any signing key of the group")
+)
+
+// разбирает открытые ключи Ed25519 хранителей в base64: по одному ключу на строку в порядке групп,
+// "-" или пустая строка означают, что часть ключа этого хранителя вводится без подписи
+func parseSigningKeys(input string, total int) ([][]byte, error) {
+	input = strings.TrimRight(input, "\r\n\t ")
+	if input == "" {
+		return nil, nil
+	}
+
+	lines := strings.Split(input, "\n")
+	if len(lines) > total {
+		return nil, fmt.Errorf("too many signing keys: %d, keyholders: %d", len(lines), total)
+	}
+
+	keys := make([][]byte, len(lines))
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || line == noRecipient {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid signing key of keyholder %d, error: %v", i+1, err)
+		}
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// привязывает открытые ключи подписи к частям ключа сообщения в порядке групп
+func assignSigningKeys(m *model.Message, keys [][]byte) {
+	for i := range keys {
+		m.Keys[i].SigningKey = keys[i]
+	}
+}
+
+// разбирает подпись части ключа в base64; пустая строка означает, что подпись не передана
+func parseSignature(input string) ([]byte, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, nil
+	}
+	if len(input) > maxSignatureLength {
+		return nil, fmt.Errorf("signature is too large, size: %d", len(input))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(input)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature, error: %v", err)
+	}
+
+	return signature, nil
+}
+
+// выбирает свободную часть ключа группы для ввода. Подписанная часть занимает место хранителя,
+// чей ключ подписи подтверждает подпись; неподписанная - только место без ключа подписи.
+// Подпись, которую не подтверждает ни один ключ группы, отклоняется, даже если подпись в группе не требуется
+func selectKeySlot(m *model.Message, groupIndex int, data []byte, signature []byte) (*model.MessageKey, error) {
+	payload := share.SigningPayload(&share.Share{MessageID: m.ID, GroupIndex: groupIndex, Data: data})
+
+	var unsigned *model.MessageKey
+	signingRequired := false
+	for i := range m.Keys {
+		key := &m.Keys[i]
+		if key.SecretPart != nil || key.GroupIndex != groupIndex {
+			continue
+		}
+
+		if key.SigningKey == nil {
+			unsigned = key
+			continue
+		}
+
+		signingRequired = true
+		if signature != nil && ed25519.Verify(key.SigningKey, payload, signature) {
+			key.Signature = signature
+			return key, nil
+		}
+	}
+
+	switch {
+	case signature != nil:
+		return nil, errInvalidSignature
+	case unsigned != nil:
+		return unsigned, nil
+	case signingRequired:
+		return nil, errSignatureRequired
+	default:
+		return nil, fmt.Errorf("failed to find empty key for message %v: %w", m.ID, errKeysFull)
+	}
+}
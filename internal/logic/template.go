@@ -0,0 +1,33 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hoisie/mustache"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/mclyashko/everlock/internal/template"
+	"github.com/mclyashko/everlock/internal/tracing"
+)
+
+// templates шаблоны страниц, задаются при настройке роутера через SetTemplates
+var templates *template.Registry
+
+// SetTemplates задает шаблоны, которыми обработчики отображают страницы
+func SetTemplates(r *template.Registry) {
+	templates = r
+}
+
+// возвращает разобранный шаблон страницы
+func getTemplate(ctx context.Context, name string) (*mustache.Template, error) {
+	_, span := tracing.Start(ctx, "template.get", attribute.String("template", name))
+	if templates == nil {
+		err := fmt.Errorf("templates are not loaded")
+		tracing.End(span, err)
+		return nil, err
+	}
+	t, err := templates.Get(name)
+	tracing.End(span, err)
+	return t, err
+}
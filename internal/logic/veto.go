@@ -0,0 +1,217 @@
+package logic
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/mclyashko/everlock/internal/crypt"
+	"github.com/mclyashko/everlock/internal/csrf"
+	"github.com/mclyashko/everlock/internal/db"
+	"github.com/mclyashko/everlock/internal/metrics"
+	"github.com/mclyashko/everlock/internal/model"
+	"github.com/mclyashko/everlock/internal/notify"
+)
+
+const (
+	maxReleaseDelayHours = 24 * 30
+	vetoCodeSize         = 16
+	releaseDelayHoursKey = "releaseDelayHours"
+	releaseAtKey         = "releaseAt"
+	vetoCodeKey          = "vetoCode"
+	creatorVetoCodeKey   = "creatorVetoCode"
+	vetoedAtKey          = "vetoedAt"
+)
+
+// обрабатывает вето раскрытия сообщения от создателя или хранителя ключа
+func VetoHandler(p *pgxpool.Pool, n notify.Notifier, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid request method, url: %s, method: %s", r.URL.Path, r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	messageID := parts[len(parts)-1]
+
+	parsedUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse uuid, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	message, err := db.GetMessageByID(ctx, p, &parsedUUID)
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to get message, error: %v", err), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	releaseAt, pending := message.ReleaseAt()
+	if !pending {
+		logErrorAndRespond(w, r, fmt.Sprintf("no pending release for message %v", message.ID), http.StatusConflict)
+		return
+	}
+	if !time.Now().Before(releaseAt) {
+		err = fmt.Errorf("veto window of message %v: %w", message.ID, errExpired)
+		logErrorAndRespond(w, r, err.Error(), errorStatus(err, http.StatusConflict))
+		return
+	}
+
+	vetoCode, err := hex.DecodeString(strings.TrimSpace(r.FormValue(vetoCodeKey)))
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to parse veto code, error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !verifyVetoCode(message, vetoCode) {
+		logErrorAndRespond(w, r, fmt.Sprintf("invalid veto code for message %v", message.ID), http.StatusForbidden)
+		return
+	}
+
+	audit, err := newAudit(message.ID, model.AuditReleaseVetoed, nil)
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wiped, err := model.NewAuditEvent(message.ID, model.AuditKeysWiped, map[string]string{"reason": "release vetoed"})
+	if err != nil {
+		logErrorAndRespond(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit.Events = append(audit.Events, wiped)
+
+	if err = db.VetoRelease(ctx, p, &message.ID, audit); err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("failed to veto release, error: %v", err), errorStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	metrics.KeyWipes.Inc()
+	logAuditEvents(ctx, audit.Events...)
+
+	notifyEvent(ctx, n, message, notify.Event{
+		Type:      notify.EventReleaseVetoed,
+		MessageID: message.ID,
+		CreatedAt: time.Now(),
+	})
+
+	http.Redirect(w, r, fmt.Sprintf("/decrypt/%s", messageID), http.StatusSeeOther)
+}
+
+// начинает окно вето при первом наборе порога частей ключа, уведомляет об этом
+// и сообщает время окончания окна и признак того, что оно истекло
+func releaseWindow(ctx context.Context, p *pgxpool.Pool, n notify.Notifier, m *model.Message) (time.Time, bool, error) {
+	now := time.Now()
+
+	releaseAt, pending := m.ReleaseAt()
+	if !pending {
+		thresholdMetAt, started, err := db.StartReleaseWindow(ctx, p, &m.ID, now)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+
+		m.ThresholdMetAt = &thresholdMetAt
+		releaseAt, _ = m.ReleaseAt()
+
+		if started {
+			recordEvent(ctx, p, m.ID, model.AuditReleasePending, map[string]string{"release_at": releaseAt.UTC().Format(time.RFC3339)})
+			notifyEvent(ctx, n, m, notify.Event{
+				Type:      notify.EventReleasePending,
+				MessageID: m.ID,
+				ReleaseAt: releaseAt,
+				CreatedAt: now,
+			})
+		}
+	}
+
+	return releaseAt, !now.Before(releaseAt), nil
+}
+
+// отображает страницу ожидания окончания окна вето
+func renderReleasePending(w http.ResponseWriter, r *http.Request, m *model.Message, releaseAt time.Time) {
+	template, err := getTemplate(r.Context(), "decrypt_pending.html")
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("error loading decrypt pending template, error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	renderedTemplate := template.Render(map[string]interface{}{
+		messageIDKey: m.ID,
+		nicknameKey:  m.CreatorName,
+		releaseAtKey: releaseAt.UTC().Format(time.RFC1123),
+		csrfTokenKey: csrf.Token(r),
+	})
+
+	w.Header().Set("Content-Type", "text/html")
+	_, err = w.Write([]byte(renderedTemplate))
+	if err != nil {
+		logErrorAndRespond(w, r, fmt.Sprintf("error writing response, error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// назначает сообщению и каждому хранителю ключа коды вето, сохраняя в сообщении только их хеши
+func assignVetoCodes(m *model.Message) (string, []string, error) {
+	creatorCode, creatorHash, err := newVetoCode()
+	if err != nil {
+		return "", nil, err
+	}
+	m.VetoHash = creatorHash
+
+	keyCodes := make([]string, len(m.Keys))
+	for i := range m.Keys {
+		keyCodes[i], m.Keys[i].VetoHash, err = newVetoCode()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return creatorCode, keyCodes, nil
+}
+
+func newVetoCode() (string, []byte, error) {
+	code, err := crypt.GenerateRandomBytes(vetoCodeSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate veto code, error: %v", err)
+	}
+
+	hash := sha256.Sum256(code)
+
+	return hex.EncodeToString(code), hash[:], nil
+}
+
+// проверяет, что код вето принадлежит создателю сообщения или одному из хранителей ключа
+func verifyVetoCode(m *model.Message, code []byte) bool {
+	hash := sha256.Sum256(code)
+
+	valid := m.VetoHash != nil && subtle.ConstantTimeCompare(hash[:], m.VetoHash) == 1
+	for _, key := range m.Keys {
+		if key.VetoHash != nil && subtle.ConstantTimeCompare(hash[:], key.VetoHash) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
+
+// разбирает окно вето в часах, пустое значение означает отсутствие окна
+func parseReleaseDelay(input string) (time.Duration, error) {
+	if input == "" {
+		return 0, nil
+	}
+
+	hours, err := strconv.Atoi(input)
+	if err != nil || hours < 0 || hours > maxReleaseDelayHours {
+		return 0, fmt.Errorf("invalid release delay: %s, error: %v", input, err)
+	}
+
+	return time.Duration(hours) * time.Hour, nil
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const namespace = "everlock"
+
+// исходы восстановления ключа
+const (
+	ReconstructionSuccess      = "success"
+	ReconstructionCombineError = "combine_error"
+	ReconstructionHashMismatch = "hash_mismatch"
+	ReconstructionDecryptError = "decrypt_error"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// MessagesCreated число созданных сообщений
+	MessagesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_created_total",
+		Help:      "Messages created.",
+	})
+
+	// SharesSubmitted число принятых частей ключа
+	SharesSubmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shares_submitted_total",
+		Help:      "Key shares accepted.",
+	})
+
+	// Reconstructions число попыток восстановления ключа и расшифровки по исходу
+	Reconstructions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconstructions_total",
+		Help:      "Key reconstructions by result: success, combine_error, hash_mismatch or decrypt_error.",
+	}, []string{"result"})
+
+	// KeyWipes число стираний введенных частей ключа
+	KeyWipes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_wipes_total",
+		Help:      "Wipes of submitted key shares.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		MessagesCreated,
+		SharesSubmitted,
+		Reconstructions,
+		KeyWipes,
+	)
+
+	// исходы заранее выставляются в 0, чтобы ряды существовали до первого события
+	for _, result := range []string{ReconstructionSuccess, ReconstructionCombineError, ReconstructionHashMismatch, ReconstructionDecryptError} {
+		Reconstructions.WithLabelValues(result)
+	}
+}
+
+// Register добавляет коллекторы, например, статистику пула подключений, в реестр метрик
+func Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler отдает метрики в формате Prometheus. Если задан пароль, требует HTTP Basic аутентификацию
+func Handler(c *config.Metrics) http.Handler {
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if c.Password == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(c.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(c.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ObserveRequest учитывает запрос к пути route роутера. Используется путь из роутера, а не из запроса,
+// чтобы идентификаторы сообщений не порождали новые ряды
+func ObserveRequest(route string, method string, status int, duration time.Duration) {
+	requests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
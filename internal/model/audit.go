@@ -0,0 +1,126 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// AuditMessageCreated сообщение создано
+	AuditMessageCreated = "message_created"
+	// AuditShareSubmitted хранитель ввел часть ключа
+	AuditShareSubmitted = "share_submitted"
+	// AuditCombineFailed введенные части ключа не удалось объединить или ключ не совпал с обязательством
+	AuditCombineFailed = "combine_failed"
+	// AuditMessageLocked клиенты, которые ввели части ключа, заблокированы после повторных неудачных восстановлений ключа
+	AuditMessageLocked = "message_locked"
+	// AuditKeysWiped введенные части ключа удалены
+	AuditKeysWiped = "keys_wiped"
+	// AuditReleasePending набран порог частей ключа и началось окно вето
+	AuditReleasePending = "release_pending"
+	// AuditReleaseVetoed раскрытие сообщения отменено вето
+	AuditReleaseVetoed = "release_vetoed"
+	// AuditMessageDecrypted сообщение расшифровано
+	AuditMessageDecrypted = "message_decrypted"
+	// AuditAttachmentDownloaded вложение сообщения расшифровано и выдано
+	AuditAttachmentDownloaded = "attachment_downloaded"
+	// AuditMessageDeleted сообщение удалено
+	AuditMessageDeleted = "message_deleted"
+
+	auditHashContext = "everlock audit event v2"
+)
+
+// AuditEvent запись журнала событий сообщения. Записи сообщения образуют цепочку:
+// хеш записи покрывает ее поля и хеш предыдущей записи, поэтому изменение или удаление записи обнаруживается.
+// Хеш вычисляется HMAC с секретом сервера, чтобы цепочку нельзя было пересчитать, имея доступ только к базе данных
+type AuditEvent struct {
+	MessageID uuid.UUID
+	Seq       int64
+	Type      string
+	Details   string
+	CreatedAt time.Time
+	PrevHash  [32]byte
+	Hash      [32]byte
+}
+
+// NewAuditEvent создает запись журнала; номер и хеши заполняются при добавлении в цепочку.
+// Время округляется до микросекунд, с которыми оно хранится в базе данных
+func NewAuditEvent(messageID uuid.UUID, eventType string, details map[string]string) (*AuditEvent, error) {
+	encodedDetails := "{}"
+	if len(details) > 0 {
+		// json.Marshal сортирует ключи, поэтому одинаковые детали дают одинаковый текст
+		b, err := json.Marshal(details)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal audit details, error: %v", err)
+		}
+		encodedDetails = string(b)
+	}
+
+	return &AuditEvent{
+		MessageID: messageID,
+		Type:      eventType,
+		Details:   encodedDetails,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}, nil
+}
+
+// Chain связывает запись с предыдущей записью цепочки; prev равен nil для первой записи сообщения
+func (e *AuditEvent) Chain(prev *AuditEvent, secret []byte) {
+	e.Seq = 1
+	e.PrevHash = [32]byte{}
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	e.Hash = e.ComputeHash(secret)
+}
+
+// ComputeHash вычисляет HMAC-SHA256 полей записи и хеша предыдущей записи
+func (e *AuditEvent) ComputeHash(secret []byte) [32]byte {
+	data := make([]byte, 0, 128+len(e.Details))
+	data = append(data, auditHashContext...)
+	data = append(data, e.MessageID[:]...)
+	data = binary.BigEndian.AppendUint64(data, uint64(e.Seq))
+	data = appendLengthPrefixed(data, []byte(e.Type))
+	data = appendLengthPrefixed(data, []byte(e.Details))
+	data = binary.BigEndian.AppendUint64(data, uint64(e.CreatedAt.UnixMicro()))
+	data = append(data, e.PrevHash[:]...)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return [32]byte(mac.Sum(nil))
+}
+
+// VerifyAuditChain проверяет цепочку записей одного сообщения и возвращает номер первой
+// неверной записи; -1 означает, что цепочка цела
+func VerifyAuditChain(events []AuditEvent, secret []byte) (int64, error) {
+	var prev *AuditEvent
+	for i := range events {
+		e := &events[i]
+
+		expectedSeq, expectedPrev := int64(1), [32]byte{}
+		if prev != nil {
+			expectedSeq, expectedPrev = prev.Seq+1, prev.Hash
+		}
+
+		hash := e.ComputeHash(secret)
+		switch {
+		case e.Seq != expectedSeq:
+			return e.Seq, fmt.Errorf("audit event %d follows event %d", e.Seq, expectedSeq-1)
+		case e.PrevHash != expectedPrev:
+			return e.Seq, fmt.Errorf("audit event %d does not link to the previous event", e.Seq)
+		case !hmac.Equal(hash[:], e.Hash[:]):
+			return e.Seq, fmt.Errorf("audit event %d hash does not match its contents", e.Seq)
+		}
+
+		prev = e
+	}
+
+	return -1, nil
+}
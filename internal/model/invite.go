@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// InviteKeyAge открытый ключ age (X25519)
+	InviteKeyAge = "age"
+	// InviteKeyOpenPGP открытый ключ OpenPGP в текстовом формате
+	InviteKeyOpenPGP = "openpgp"
+)
+
+// Invite приглашение хранителю зарегистрировать открытый ключ, которым будет зашифрована его часть ключа
+type Invite struct {
+	ID           uuid.UUID
+	Label        string
+	PublicKey    string
+	KeyType      string
+	CreatedAt    time.Time
+	RegisteredAt *time.Time
+}
+
+// NewInvite создает приглашение без открытого ключа
+func NewInvite(label string) *Invite {
+	return &Invite{
+		ID:        uuid.New(),
+		Label:     label,
+		CreatedAt: time.Now(),
+	}
+}
+
+// IsRegistered сообщает, что хранитель уже зарегистрировал открытый ключ
+func (i *Invite) IsRegistered() bool {
+	return i.RegisteredAt != nil
+}
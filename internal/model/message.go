@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,40 +11,129 @@ import (
 type MessageKey struct {
 	ID         uuid.UUID
 	MessageID  uuid.UUID
+	GroupIndex int
 	SecretPart []byte
-	UpdatedAt  time.Time
+	VetoHash   []byte
+	SigningKey []byte
+	Signature  []byte
+	// SubmittedBy идентификатор клиента, который ввел часть ключа; пуст у частей, введенных до его учета
+	SubmittedBy string
+	// NotifyURL адрес, на который хранитель получает уведомления; пустой, если не задан
+	NotifyURL string
+	UpdatedAt time.Time
+}
+
+type Attachment struct {
+	ID            uuid.UUID
+	MessageID     uuid.UUID
+	EncryptedName []byte
+	Size          int64
+	CreatedAt     time.Time
 }
 
 type Message struct {
 	ID               uuid.UUID
 	CreatorName      string
 	EncryptedContent []byte
-	KeyHash          [32]byte
-	MinKeyholders    int
-	CreatedAt        time.Time
-	Keys             []MessageKey
+	// ContentVersion версия формата EncryptedContent; 0 у записей, зашифрованных до появления версий
+	ContentVersion byte
+	KeyCommitment  [32]byte
+	KeySalt        []byte
+	MinKeyholders  int
+	Policy         *Policy
+	ReleaseDelay   time.Duration
+	ThresholdMetAt *time.Time
+	VetoedAt       *time.Time
+	VetoHash       []byte
+	// NotifyURL адрес, на который создатель получает уведомления; пустой, если не задан
+	NotifyURL   string
+	CreatedAt   time.Time
+	Keys        []MessageKey
+	Attachments []Attachment
 }
 
-func NewMessage(nickname string, encryptedMessage []byte, keyHash [32]byte, keyholders int, minKeyholders int) *Message {
+// NewMessage создает сообщение без содержимого: шифротекст и обязательство ключа привязываются
+// к метаданным сообщения, поэтому EncryptedContent, KeySalt и KeyCommitment заполняются после создания
+func NewMessage(nickname string, policy *Policy, releaseDelay time.Duration) *Message {
 	message := Message{
-		ID:               uuid.New(),
-		CreatorName:      nickname,
-		EncryptedContent: encryptedMessage,
-		KeyHash:          keyHash,
-		MinKeyholders:    minKeyholders,
-		CreatedAt:        time.Now(),
+		ID:            uuid.New(),
+		CreatorName:   nickname,
+		MinKeyholders: policy.MinShares(),
+		Policy:        policy,
+		ReleaseDelay:  releaseDelay,
+		CreatedAt:     time.Now(),
 	}
 
-	messageKeys := make([]MessageKey, keyholders)
-	for i := 0; i < keyholders; i++ {
-		messageKeys[i] = MessageKey{
-			ID:         uuid.New(),
-			MessageID:  message.ID,
-			SecretPart: nil,
-			UpdatedAt:  message.CreatedAt,
+	messageKeys := make([]MessageKey, 0, policy.TotalMembers())
+	for groupIndex, group := range policy.Groups {
+		for i := 0; i < group.Members; i++ {
+			messageKeys = append(messageKeys, MessageKey{
+				ID:         uuid.New(),
+				MessageID:  message.ID,
+				GroupIndex: groupIndex,
+				SecretPart: nil,
+				UpdatedAt:  message.CreatedAt,
+			})
 		}
 	}
 	message.Keys = messageKeys
 
 	return &message
 }
+
+// NewAttachment создает вложение сообщения без содержимого; вызывающий добавляет его в Attachments
+func (m *Message) NewAttachment() Attachment {
+	return Attachment{
+		ID:        uuid.New(),
+		MessageID: m.ID,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// ReleaseAt возвращает время окончания окна вето, если порог частей ключа уже набран
+func (m *Message) ReleaseAt() (time.Time, bool) {
+	if m.ThresholdMetAt == nil {
+		return time.Time{}, false
+	}
+	return m.ThresholdMetAt.Add(m.ReleaseDelay), true
+}
+
+// HasLegacyContent сообщает, что содержимое зашифровано в формате без заголовка и привязки к метаданным
+func (m *Message) HasLegacyContent() bool {
+	return m.ContentVersion == 0
+}
+
+// HasLegacyKeyHash сообщает, что вместо обязательства ключа хранится его несоленый хеш
+func (m *Message) HasLegacyKeyHash() bool {
+	return m.KeySalt == nil
+}
+
+// AssociatedData возвращает метаданные сообщения, к которым привязывается шифротекст:
+// идентификатор, ник создателя, порог, политику и окно вето
+func (m *Message) AssociatedData() ([]byte, error) {
+	policy, err := json.Marshal(m.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	ad := []byte("everlock message")
+	ad = append(ad, m.ID[:]...)
+	ad = appendLengthPrefixed(ad, []byte(m.CreatorName))
+	ad = binary.BigEndian.AppendUint32(ad, uint32(m.MinKeyholders))
+	ad = appendLengthPrefixed(ad, policy)
+	ad = binary.BigEndian.AppendUint64(ad, uint64(m.ReleaseDelay/time.Second))
+
+	return ad, nil
+}
+
+// AssociatedData возвращает метаданные вложения, к которым привязываются его имя и содержимое
+func (a *Attachment) AssociatedData() []byte {
+	ad := []byte("everlock attachment")
+	ad = append(ad, a.MessageID[:]...)
+	return append(ad, a.ID[:]...)
+}
+
+func appendLengthPrefixed(dst []byte, data []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(data)))
+	return append(dst, data...)
+}
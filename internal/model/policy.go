@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Group описывает группу хранителей ключа: из Members участников для восстановления
+// секрета группы нужны Threshold частей
+type Group struct {
+	Name      string `json:"name"`
+	Members   int    `json:"members"`
+	Threshold int    `json:"threshold"`
+}
+
+// Policy описывает иерархическую пороговую схему: ключ делится на секреты групп,
+// для расшифровки нужны секреты Threshold групп
+type Policy struct {
+	Threshold int     `json:"threshold"`
+	Groups    []Group `json:"groups"`
+}
+
+// NewSingleGroupPolicy создает политику из одной группы, эквивалентную простой схеме "minKeyholders из keyholders"
+func NewSingleGroupPolicy(keyholders int, minKeyholders int) *Policy {
+	return &Policy{
+		Threshold: 1,
+		Groups: []Group{
+			{
+				Members:   keyholders,
+				Threshold: minKeyholders,
+			},
+		},
+	}
+}
+
+// Validate проверяет согласованность порогов политики
+func (p *Policy) Validate() error {
+	if len(p.Groups) == 0 {
+		return fmt.Errorf("policy has no groups")
+	}
+
+	if p.Threshold < 1 || p.Threshold > len(p.Groups) {
+		return fmt.Errorf("invalid group threshold: %d, groups: %d", p.Threshold, len(p.Groups))
+	}
+
+	for i, g := range p.Groups {
+		if g.Members < 1 {
+			return fmt.Errorf("group %d has no members", i)
+		}
+		if g.Threshold < 1 || g.Threshold > g.Members {
+			return fmt.Errorf("invalid threshold for group %d: %d, members: %d", i, g.Threshold, g.Members)
+		}
+	}
+
+	return nil
+}
+
+// TotalMembers возвращает общее количество хранителей ключа во всех группах
+func (p *Policy) TotalMembers() int {
+	total := 0
+	for _, g := range p.Groups {
+		total += g.Members
+	}
+	return total
+}
+
+// MinShares возвращает минимальное количество частей ключа, достаточное для расшифровки
+func (p *Policy) MinShares() int {
+	thresholds := make([]int, len(p.Groups))
+	for i, g := range p.Groups {
+		thresholds[i] = g.Threshold
+	}
+	sort.Ints(thresholds)
+
+	total := 0
+	for i := 0; i < p.Threshold && i < len(thresholds); i++ {
+		total += thresholds[i]
+	}
+	return total
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"log/slog"
+)
+
+// Записи журнала и сообщения об ошибках не должны содержать шифротекст, части ключа, подписи и хеши кодов вето.
+// Поэтому модели при выводе через slog и fmt (%v, %+v, %#v) раскрываются только идентификаторами и счетчиками
+
+// LogValue реализует slog.LogValuer
+func (m Message) LogValue() slog.Value {
+	submitted := 0
+	for _, k := range m.Keys {
+		if k.SecretPart != nil {
+			submitted++
+		}
+	}
+
+	return slog.GroupValue(
+		slog.String("id", m.ID.String()),
+		slog.Int("keys", len(m.Keys)),
+		slog.Int("submitted", submitted),
+		slog.Int("attachments", len(m.Attachments)),
+	)
+}
+
+func (m Message) String() string {
+	return "Message" + m.LogValue().String()
+}
+
+func (m Message) GoString() string {
+	return m.String()
+}
+
+// LogValue реализует slog.LogValuer
+func (k MessageKey) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", k.ID.String()),
+		slog.String("message_id", k.MessageID.String()),
+		slog.Int("group", k.GroupIndex),
+		slog.Bool("submitted", k.SecretPart != nil),
+	)
+}
+
+func (k MessageKey) String() string {
+	return "MessageKey" + k.LogValue().String()
+}
+
+func (k MessageKey) GoString() string {
+	return k.String()
+}
+
+// LogValue реализует slog.LogValuer
+func (a Attachment) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", a.ID.String()),
+		slog.String("message_id", a.MessageID.String()),
+		slog.Int64("size", a.Size),
+	)
+}
+
+func (a Attachment) String() string {
+	return "Attachment" + a.LogValue().String()
+}
+
+func (a Attachment) GoString() string {
+	return a.String()
+}
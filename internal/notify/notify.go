@@ -0,0 +1,261 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const (
+	// EventReleasePending отправляется, когда набран порог частей ключа и началось окно вето
+	EventReleasePending = "release_pending"
+	// EventReleaseVetoed отправляется, когда выпуск сообщения отменен вето
+	EventReleaseVetoed = "release_vetoed"
+
+	// RoleCreator получатель уведомления - создатель сообщения
+	RoleCreator = "creator"
+	// RoleKeyholder получатель уведомления - хранитель части ключа
+	RoleKeyholder = "keyholder"
+
+	// queueSize число уведомлений, ожидающих отправки; при переполнении очереди новые уведомления отбрасываются
+	queueSize = 256
+	// workers число одновременно отправляемых уведомлений
+	workers = 4
+	// maxAttempts число попыток доставить уведомление на один адрес
+	maxAttempts = 3
+	// retryDelay пауза перед второй попыткой доставки, перед каждой следующей она удваивается
+	retryDelay = 5 * time.Second
+)
+
+// sharedAddressSpace подсеть операторов связи (RFC 6598), которую netip не считает частной
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Event описывает событие сообщения, о котором уведомляются создатель и хранители ключа
+type Event struct {
+	Type      string    `json:"type"`
+	MessageID uuid.UUID `json:"message_id"`
+	Recipient string    `json:"recipient,omitempty"`
+	ReleaseAt time.Time `json:"release_at,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Target адрес, на который получатель сообщения принимает уведомления
+type Target struct {
+	Role string
+	URL  string
+}
+
+// Notifier доставляет уведомления о событиях сообщений
+type Notifier interface {
+	// Notify ставит событие в очередь доставки в общий канал из конфигурации и на адрес каждого получателя
+	// из targets и не ждет отправки. Возвращает ошибку, если событие не поставлено в очередь
+	Notify(e Event, targets []Target) error
+}
+
+// одно уведомление для одного адреса
+type delivery struct {
+	client *http.Client
+	url    string
+	event  Event
+}
+
+// WebhookNotifier отправляет уведомления POST-запросом с JSON-телом в фоне: уведомления ставятся в очередь
+// ограниченного размера и отправляются ограниченным числом обработчиков с повторными попытками, поэтому
+// медленный или недоступный адрес не задерживает запрос. Общий канал - адрес URL из конфигурации,
+// без него уведомления пишутся в журнал. Адреса получателей задают пользователи, поэтому запросы к ним
+// отправляются клиентом TargetClient, который не подключается к адресам внутренних сетей
+type WebhookNotifier struct {
+	URL          string
+	Client       *http.Client
+	TargetClient *http.Client
+	Timeout      time.Duration
+
+	queue chan delivery
+	// abort закрывается, когда время на отправку оставшихся уведомлений при остановке истекло
+	abort chan struct{}
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewNotifier создает нотификатор с общим каналом из конфигурации; отправка начинается после Start
+func NewNotifier(c *config.Notify) *WebhookNotifier {
+	dialer := &net.Dialer{Control: publicOnly}
+
+	return &WebhookNotifier{
+		URL:    c.WebhookURL,
+		Client: &http.Client{},
+		TargetClient: &http.Client{
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		Timeout: c.Timeout,
+		queue:   make(chan delivery, queueSize),
+		abort:   make(chan struct{}),
+	}
+}
+
+// Start запускает обработчики очереди уведомлений
+func (n *WebhookNotifier) Start() {
+	for i := 0; i < workers; i++ {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			for d := range n.queue {
+				n.deliver(d)
+			}
+		}()
+	}
+}
+
+// Close перестает принимать уведомления и ждет отправки поставленных в очередь, но не дольше, чем до отмены ctx.
+// После отмены ctx оставшиеся уведомления отбрасываются
+func (n *WebhookNotifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		close(n.abort)
+		<-finished
+		return fmt.Errorf("pending notifications were dropped, error: %w", ctx.Err())
+	}
+}
+
+func (n *WebhookNotifier) Notify(e Event, targets []Target) error {
+	deliveries := make([]delivery, 0, len(targets)+1)
+	for _, t := range targets {
+		te := e
+		te.Recipient = t.Role
+		deliveries = append(deliveries, delivery{client: n.TargetClient, url: t.URL, event: te})
+	}
+
+	if n.URL == "" {
+		slog.Info("notification", "type", e.Type, "message_id", e.MessageID, "release_at", e.ReleaseAt, "recipients", len(targets))
+	} else {
+		deliveries = append(deliveries, delivery{client: n.Client, url: n.URL, event: e})
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.closed {
+		return errors.New("notifier is closed")
+	}
+
+	dropped := 0
+	for _, d := range deliveries {
+		select {
+		case n.queue <- d:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("notification queue is full, dropped %d of %d notifications", dropped, len(deliveries))
+	}
+
+	return nil
+}
+
+// deliver отправляет уведомление, повторяя неудачные попытки с растущей паузой
+func (n *WebhookNotifier) deliver(d delivery) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-n.abort:
+			slog.Error("notification dropped on shutdown", "type", d.event.Type, "message_id", d.event.MessageID, "recipient", d.event.Recipient)
+			return
+		default:
+		}
+
+		err := n.post(d.client, d.url, d.event)
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			slog.Error("failed to send notification", "type", d.event.Type, "message_id", d.event.MessageID, "recipient", d.event.Recipient, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("failed to send notification, retrying", "type", d.event.Type, "message_id", d.event.MessageID, "recipient", d.event.Recipient, "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-n.abort:
+		}
+		delay *= 2
+	}
+}
+
+func (n *WebhookNotifier) post(client *http.Client, url string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event, error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request, error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook, error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded with status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// publicOnly запрещает подключение к адресам, которые не являются публичными адресами интернета
+func publicOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("notification address %s is not public", ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const (
+	challengeVersion = 1
+	randomSize       = 16
+	macSize          = sha256.Size
+	// версия, время истечения, сложность и случайные байты
+	payloadSize   = 1 + 8 + 1 + randomSize
+	maxNonceSize  = 32
+	maxDifficulty = config.MaxPowDifficulty
+)
+
+var (
+	// ErrInvalidChallenge задача подделана, повреждена или выдана с другим секретом
+	ErrInvalidChallenge = errors.New("invalid proof-of-work challenge")
+	// ErrExpiredChallenge истек срок действия задачи
+	ErrExpiredChallenge = errors.New("proof-of-work challenge expired")
+	// ErrUsedChallenge задача уже была решена и использована
+	ErrUsedChallenge = errors.New("proof-of-work challenge already used")
+	// ErrInsufficientWork решение не набирает нужного числа нулевых бит
+	ErrInsufficientWork = errors.New("insufficient proof of work")
+)
+
+// Issuer выдает и проверяет задачи hashcash: клиент подбирает nonce, при котором SHA-256("<задача>:<nonce>")
+// начинается с заданного числа нулевых бит. Задачи подписаны HMAC, поэтому сервер их не хранит;
+// использованные задачи запоминаются до истечения в памяти процесса
+type Issuer struct {
+	secret     []byte
+	difficulty int
+	ttl        time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// NewIssuer создает Issuer из конфигурации; без секрета используется случайный секрет процесса,
+// поэтому при нескольких репликах секрет нужно задать явно
+func NewIssuer(c *config.Abuse) (*Issuer, error) {
+	if c.PowDifficulty < 0 || c.PowDifficulty > maxDifficulty {
+		return nil, fmt.Errorf("invalid proof-of-work difficulty: %d, max: %d", c.PowDifficulty, maxDifficulty)
+	}
+
+	secret := []byte(c.PowSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, sha256.Size)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate proof-of-work secret, error: %v", err)
+		}
+	}
+
+	return &Issuer{
+		secret:     secret,
+		difficulty: c.PowDifficulty,
+		ttl:        c.PowTTL,
+		used:       make(map[string]time.Time),
+	}, nil
+}
+
+// Enabled сообщает, что для создания сообщения требуется доказательство работы
+func (i *Issuer) Enabled() bool {
+	return i.difficulty > 0
+}
+
+// Difficulty число нулевых бит, которое должно быть в начале хеша решения
+func (i *Issuer) Difficulty() int {
+	return i.difficulty
+}
+
+// NewChallenge выдает новую задачу
+func (i *Issuer) NewChallenge() (string, error) {
+	payload := make([]byte, 0, payloadSize+macSize)
+	payload = append(payload, challengeVersion)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(time.Now().Add(i.ttl).Unix()))
+	payload = append(payload, byte(i.difficulty))
+
+	random := make([]byte, randomSize)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate proof-of-work challenge, error: %v", err)
+	}
+	payload = append(payload, random...)
+
+	return base64.RawURLEncoding.EncodeToString(i.sign(payload)), nil
+}
+
+// Verify проверяет решение задачи и отмечает задачу использованной
+func (i *Issuer) Verify(challenge string, nonce string, now time.Time) error {
+	if len(nonce) == 0 || len(nonce) > maxNonceSize {
+		return ErrInsufficientWork
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(data) != payloadSize+macSize {
+		return ErrInvalidChallenge
+	}
+
+	payload := data[:payloadSize]
+	if !hmac.Equal(i.sign(payload), data) || payload[0] != challengeVersion {
+		return ErrInvalidChallenge
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[1:9])), 0)
+	if !now.Before(expiresAt) {
+		return ErrExpiredChallenge
+	}
+
+	// сложность берется из задачи, чтобы смена настройки не ломала уже выданные задачи
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) < int(payload[9]) {
+		return ErrInsufficientWork
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for c, expiry := range i.used {
+		if !now.Before(expiry) {
+			delete(i.used, c)
+		}
+	}
+
+	if _, ok := i.used[challenge]; ok {
+		return ErrUsedChallenge
+	}
+	i.used[challenge] = expiresAt
+
+	return nil
+}
+
+func (i *Issuer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write(payload)
+	return mac.Sum(payload[:len(payload):len(payload)])
+}
+
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
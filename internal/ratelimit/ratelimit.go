@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+const (
+	realIPHeader = "X-Real-IP"
+	// ограничитель ключа, к которому не обращались дольше idleTimeout, удаляется
+	idleTimeout     = 10 * time.Minute
+	cleanupInterval = time.Minute
+)
+
+// KeyFunc возвращает ключ, по которому считается частота запросов; пустой ключ не ограничивается
+type KeyFunc func(r *http.Request) string
+
+// Limiter ограничивает частоту запросов по ключу алгоритмом token bucket.
+// Состояние хранится в памяти процесса, поэтому при нескольких репликах лимит действует в каждой из них
+type Limiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLimiter создает ограничитель perMinute запросов в минуту с запасом burst и запускает очистку неактивных ключей,
+// которая останавливается при отмене ctx
+func NewLimiter(ctx context.Context, perMinute float64, burst int) *Limiter {
+	l := &Limiter{
+		limit:   rate.Limit(perMinute / 60),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				l.cleanup(now)
+			}
+		}
+	}()
+
+	return l
+}
+
+// Reserve расходует токен ключа и возвращает время до следующей разрешенной попытки, если токена нет
+func (l *Limiter) Reserve(key string) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	if b.limiter.AllowN(now, 1) {
+		return 0, true
+	}
+
+	if l.limit <= 0 {
+		return time.Duration(math.MaxInt64), false
+	}
+	return time.Duration(float64(time.Second) / float64(l.limit)), false
+}
+
+func (l *Limiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware пропускает запрос к next, только если у всех ключей запроса есть токены,
+// иначе отвечает 429 с заголовком Retry-After
+func Middleware(next http.HandlerFunc, rules ...Rule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rules {
+			key := rule.Key(r)
+			if key == "" {
+				continue
+			}
+
+			if retryAfter, ok := rule.Limiter.Reserve(key); !ok {
+				slog.WarnContext(r.Context(), "rate limit exceeded", "path", r.URL.Path, "limit_key", key)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// Rule ограничитель и способ получения ключа запроса
+type Rule struct {
+	Limiter *Limiter
+	Key     KeyFunc
+}
+
+// ClientIP определяет адрес клиента: X-Real-IP учитывается, только если запрос пришел от доверенного прокси
+type ClientIP struct {
+	trustedProxies []netip.Prefix
+}
+
+// NewClientIP разбирает подсети доверенных прокси из конфигурации
+func NewClientIP(c *config.RateLimit) (*ClientIP, error) {
+	ip := &ClientIP{}
+	for _, proxy := range c.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s, error: %v", proxy, err)
+		}
+		ip.trustedProxies = append(ip.trustedProxies, prefix)
+	}
+	return ip, nil
+}
+
+// Key возвращает адрес клиента как ключ ограничения частоты
+func (c *ClientIP) Key(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	remote = remote.Unmap()
+
+	if realIP := strings.TrimSpace(r.Header.Get(realIPHeader)); realIP != "" && c.trusted(remote) {
+		if addr, err := netip.ParseAddr(realIP); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+
+	return remote.String()
+}
+
+func (c *ClientIP) trusted(addr netip.Addr) bool {
+	for _, prefix := range c.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageID возвращает идентификатор сообщения из второго сегмента пути, например, /add_key/{id}
+// или /attachment/{id}/{attachment id}. Запросы с неверным идентификатором ограничиваются только по адресу клиента
+func MessageID(r *http.Request) string {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
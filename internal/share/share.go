@@ -0,0 +1,200 @@
+package share
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	armorBegin     = "-----BEGIN EVERLOCK SHARE-----"
+	armorEnd       = "-----END EVERLOCK SHARE-----"
+	messageHeader  = "Message"
+	groupHeader    = "Group"
+	headerSplitter = ":"
+	uriPrefix      = "everlock-share:"
+	uriSplitter    = ":"
+	signingContext = "everlock share signature v1"
+
+	// NoGroup означает, что группа в части ключа не указана (старый формат)
+	NoGroup = -1
+)
+
+// Share часть ключа сообщения вместе с данными, необходимыми для ее ввода
+type Share struct {
+	MessageID  uuid.UUID
+	GroupIndex int
+	Data       []byte
+}
+
+// LogValue реализует slog.LogValuer: в журнал попадают только сообщение и группа, но не сама часть ключа
+func (s Share) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("message_id", s.MessageID.String()),
+		slog.Int("group", s.GroupIndex),
+	)
+}
+
+// String скрывает часть ключа при выводе через fmt
+func (s Share) String() string {
+	return "Share" + s.LogValue().String()
+}
+
+func (s Share) GoString() string {
+	return s.String()
+}
+
+// Encode кодирует часть ключа в текстовый блок, который можно скопировать, зашифровать или напечатать
+func Encode(s *Share) string {
+	var b strings.Builder
+
+	b.WriteString(armorBegin + "\n")
+	fmt.Fprintf(&b, "%s%s %s\n", messageHeader, headerSplitter, s.MessageID)
+	fmt.Fprintf(&b, "%s%s %d\n", groupHeader, headerSplitter, s.GroupIndex)
+	b.WriteString("\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(s.Data) + "\n")
+	b.WriteString(armorEnd + "\n")
+
+	return b.String()
+}
+
+// EncodeURI кодирует часть ключа в короткую строку "everlock-share:<сообщение>:<группа>:<данные>" для QR-кода
+func EncodeURI(s *Share) string {
+	return fmt.Sprintf("%s%s%s%d%s%s", uriPrefix, s.MessageID, uriSplitter, s.GroupIndex, uriSplitter, base64.RawURLEncoding.EncodeToString(s.Data))
+}
+
+// SigningPayload возвращает данные, которые хранитель подписывает своим ключом Ed25519 при вводе части ключа:
+// контекст подписи, идентификатор сообщения, индекс группы и саму часть ключа
+func SigningPayload(s *Share) []byte {
+	payload := make([]byte, 0, len(signingContext)+len(s.MessageID)+4+len(s.Data))
+	payload = append(payload, signingContext...)
+	payload = append(payload, s.MessageID[:]...)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(s.GroupIndex))
+	return append(payload, s.Data...)
+}
+
+// Decode разбирает часть ключа в текстовом блоке Encode, в строке EncodeURI из QR-кода или в старом формате списка байт "[1 2 3]".
+// Текст вокруг блока, например, вывод gpg --decrypt вместе с сообщениями о подписи, пропускается.
+// Для старого формата идентификатор сообщения не заполняется, а группа равна NoGroup
+func Decode(input string) (*Share, error) {
+	input = strings.TrimSpace(input)
+
+	if strings.HasPrefix(input, uriPrefix) {
+		return decodeURI(strings.TrimPrefix(input, uriPrefix))
+	}
+
+	begin := strings.Index(input, armorBegin)
+	if begin < 0 {
+		data, err := parseByteArray(input)
+		if err != nil {
+			return nil, err
+		}
+		return &Share{GroupIndex: NoGroup, Data: data}, nil
+	}
+
+	return decodeArmored(input[begin:])
+}
+
+func decodeArmored(input string) (*Share, error) {
+	s := &Share{GroupIndex: NoGroup}
+
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Scan() // armorBegin
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			break
+		}
+
+		name, value, found := strings.Cut(line, headerSplitter)
+		if !found {
+			// строка не выводится: без пустой строки после заголовков это может быть сама часть ключа
+			return nil, fmt.Errorf("invalid share header")
+		}
+		value = strings.TrimSpace(value)
+
+		switch name {
+		case messageHeader:
+			messageID, err := uuid.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid share message id: %s, error: %v", value, err)
+			}
+			s.MessageID = messageID
+		case groupHeader:
+			groupIndex, err := strconv.Atoi(value)
+			if err != nil || groupIndex < 0 {
+				return nil, fmt.Errorf("invalid share group: %s, error: %v", value, err)
+			}
+			s.GroupIndex = groupIndex
+		}
+	}
+
+	var body strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == armorEnd {
+			data, err := base64.StdEncoding.DecodeString(body.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid share data, error: %v", err)
+			}
+			s.Data = data
+			return s, nil
+		}
+		body.WriteString(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("share is truncated")
+}
+
+func decodeURI(input string) (*Share, error) {
+	parts := strings.Split(input, uriSplitter)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid share uri")
+	}
+
+	messageID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid share message id: %s, error: %v", parts[0], err)
+	}
+
+	groupIndex, err := strconv.Atoi(parts[1])
+	if err != nil || groupIndex < 0 {
+		return nil, fmt.Errorf("invalid share group: %s, error: %v", parts[1], err)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid share data, error: %v", err)
+	}
+
+	return &Share{MessageID: messageID, GroupIndex: groupIndex, Data: data}, nil
+}
+
+func parseByteArray(input string) ([]byte, error) {
+	trimmed := strings.Trim(input, "[]")
+
+	parts := strings.Fields(trimmed)
+
+	bytes := make([]byte, len(parts))
+	for i, part := range parts {
+		var num int
+		_, err := fmt.Sscanf(part, "%d", &num)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse byte: %v", err)
+		}
+		bytes[i] = byte(num)
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,105 @@
+// Решает задачу доказательства работы перед отправкой формы создания сообщения:
+// подбирает nonce, при котором SHA-256("<задача>:<nonce>") начинается с нужного числа нулевых бит.
+// SHA-256 реализован здесь же, потому что crypto.subtle доступен только на HTTPS.
+(function () {
+  "use strict";
+
+  var K = [
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+    0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+    0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+    0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+    0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+    0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2
+  ];
+
+  function rotr(x, n) {
+    return (x >>> n) | (x << (32 - n));
+  }
+
+  // хеширует ASCII-строку и возвращает 8 слов дайджеста
+  function sha256(text) {
+    var length = text.length;
+    var blocks = ((length + 9 + 63) >> 6) << 4;
+    var words = new Array(blocks).fill(0);
+    for (var i = 0; i < length; i++) {
+      words[i >> 2] |= (text.charCodeAt(i) & 0xff) << (24 - (i & 3) * 8);
+    }
+    words[length >> 2] |= 0x80 << (24 - (length & 3) * 8);
+    words[blocks - 1] = length * 8;
+
+    var h = [0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19];
+    var w = new Array(64);
+    for (var block = 0; block < blocks; block += 16) {
+      for (var t = 0; t < 64; t++) {
+        if (t < 16) {
+          w[t] = words[block + t] | 0;
+        } else {
+          var s0 = rotr(w[t - 15], 7) ^ rotr(w[t - 15], 18) ^ (w[t - 15] >>> 3);
+          var s1 = rotr(w[t - 2], 17) ^ rotr(w[t - 2], 19) ^ (w[t - 2] >>> 10);
+          w[t] = (w[t - 16] + s0 + w[t - 7] + s1) | 0;
+        }
+      }
+
+      var a = h[0], b = h[1], c = h[2], d = h[3], e = h[4], f = h[5], g = h[6], hh = h[7];
+      for (var r = 0; r < 64; r++) {
+        var S1 = rotr(e, 6) ^ rotr(e, 11) ^ rotr(e, 25);
+        var ch = (e & f) ^ (~e & g);
+        var temp1 = (hh + S1 + ch + K[r] + w[r]) | 0;
+        var S0 = rotr(a, 2) ^ rotr(a, 13) ^ rotr(a, 22);
+        var maj = (a & b) ^ (a & c) ^ (b & c);
+        var temp2 = (S0 + maj) | 0;
+        hh = g; g = f; f = e; e = (d + temp1) | 0;
+        d = c; c = b; b = a; a = (temp1 + temp2) | 0;
+      }
+
+      h[0] = (h[0] + a) | 0; h[1] = (h[1] + b) | 0; h[2] = (h[2] + c) | 0; h[3] = (h[3] + d) | 0;
+      h[4] = (h[4] + e) | 0; h[5] = (h[5] + f) | 0; h[6] = (h[6] + g) | 0; h[7] = (h[7] + hh) | 0;
+    }
+    return h;
+  }
+
+  function leadingZeroBits(digest) {
+    var count = 0;
+    for (var i = 0; i < digest.length; i++) {
+      if (digest[i] === 0) {
+        count += 32;
+        continue;
+      }
+      return count + Math.clz32(digest[i]);
+    }
+    return count;
+  }
+
+  var form = document.querySelector("form[data-pow-challenge]");
+  if (!form) {
+    return;
+  }
+
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+
+    var challenge = form.getAttribute("data-pow-challenge");
+    var difficulty = parseInt(form.getAttribute("data-pow-difficulty"), 10);
+    var button = form.querySelector("button[type=submit]");
+    button.disabled = true;
+    button.textContent = "Computing proof of work...";
+
+    var nonce = 0;
+    // перебор идет порциями, чтобы страница не зависала
+    (function search() {
+      for (var end = nonce + 5000; nonce < end; nonce++) {
+        if (leadingZeroBits(sha256(challenge + ":" + nonce)) >= difficulty) {
+          var action = form.getAttribute("action");
+          form.action = action + (action.indexOf("?") < 0 ? "?" : "&") +
+            "powChallenge=" + encodeURIComponent(challenge) + "&powNonce=" + nonce;
+          form.submit();
+          return;
+        }
+      }
+      setTimeout(search, 0);
+    })();
+  });
+})();
@@ -0,0 +1,33 @@
+package static
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+//go:embed *.css *.js
+var embedded embed.FS
+
+// FS возвращает статические файлы: файлы из dir используются вместо встроенных с тем же именем.
+// Пустой dir означает только встроенные файлы
+func FS(dir string) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return overlay{dir: os.DirFS(dir)}
+}
+
+// файлы каталога поверх встроенных
+type overlay struct {
+	dir fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return embedded.Open(name)
+	}
+	return f, err
+}
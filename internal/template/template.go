@@ -0,0 +1,156 @@
+package template
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hoisie/mustache"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+//go:embed *.html
+var embedded embed.FS
+
+// шаблон вместе с файлом, из которого он прочитан
+type entry struct {
+	template *mustache.Template
+	// path путь к файлу в каталоге замены; пустой для встроенного шаблона
+	path    string
+	modTime time.Time
+}
+
+// Registry шаблоны страниц, разобранные один раз при запуске.
+// Шаблоны из каталога замены используются вместо встроенных с тем же именем
+type Registry struct {
+	dir    string
+	reload bool
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+// NewRegistry разбирает встроенные шаблоны и шаблоны из c.Dir; ошибка в любом шаблоне возвращается сразу,
+// чтобы сервер не запускался с неработающей страницей
+func NewRegistry(c *config.Templates) (*Registry, error) {
+	// несуществующий каталог замены означает опечатку в пути, а не отсутствие своих шаблонов
+	if c.Dir != "" {
+		if info, err := os.Stat(c.Dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid template dir %s, error: %v", c.Dir, err)
+		}
+	}
+
+	r := &Registry{
+		dir:     c.Dir,
+		reload:  c.Reload,
+		entries: make(map[string]*entry),
+	}
+
+	names, err := fs.Glob(embedded, "*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list embedded templates, error: %v", err)
+	}
+
+	for _, name := range names {
+		e, err := r.load(name)
+		if err != nil {
+			return nil, err
+		}
+		r.entries[name] = e
+	}
+
+	return r, nil
+}
+
+// Names возвращает имена всех шаблонов
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get возвращает разобранный шаблон. В режиме перезагрузки шаблон перечитывается, если файл в каталоге замены
+// появился, изменился или был удален
+func (r *Registry) Get(name string) (*mustache.Template, error) {
+	r.mu.RLock()
+	e, ok := r.entries[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown template: %s", name)
+	}
+
+	if !r.reload || !r.changed(name, e) {
+		return e.template, nil
+	}
+
+	e, err := r.load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.entries[name] = e
+	r.mu.Unlock()
+
+	slog.Info("template reloaded", "template", name, "path", e.path)
+	return e.template, nil
+}
+
+// сравнивает файл шаблона в каталоге замены с уже разобранным
+func (r *Registry) changed(name string, e *entry) bool {
+	if r.dir == "" {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(r.dir, name))
+	if err != nil {
+		return e.path != ""
+	}
+	return e.path == "" || !info.ModTime().Equal(e.modTime)
+}
+
+// разбирает шаблон из каталога замены, а если его там нет, встроенный шаблон
+func (r *Registry) load(name string) (*entry, error) {
+	if r.dir != "" {
+		path := filepath.Join(r.dir, name)
+		info, err := os.Stat(path)
+		switch {
+		case err == nil:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read template %s, error: %v", path, err)
+			}
+			template, err := mustache.ParseString(string(data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse template %s, error: %v", path, err)
+			}
+			return &entry{template: template, path: path, modTime: info.ModTime()}, nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, fmt.Errorf("failed to stat template %s, error: %v", path, err)
+		}
+	}
+
+	data, err := embedded.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded template %s, error: %v", name, err)
+	}
+	template, err := mustache.ParseString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse embedded template %s, error: %v", name, err)
+	}
+	return &entry{template: template}, nil
+}
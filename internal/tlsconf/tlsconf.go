@@ -0,0 +1,210 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+var minVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Reloader хранит текущий сертификат сервера и подменяет его, когда файлы сертификата или ключа меняются,
+// чтобы обновление сертификата не требовало перезапуска
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader загружает сертификат и ключ из файлов
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	rl := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := rl.Reload(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Reload перечитывает сертификат и ключ; при ошибке продолжает использоваться прежний сертификат
+func (rl *Reloader) Reload() error {
+	modTime, err := rl.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(rl.certFile, rl.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls certificate %s, error: %v", rl.certFile, err)
+	}
+
+	rl.mu.Lock()
+	rl.cert = &cert
+	rl.modTime = modTime
+	rl.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate возвращает текущий сертификат, подходит для tls.Config.GetCertificate
+func (rl *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.cert, nil
+}
+
+// Watch перечитывает сертификат по сигналу SIGHUP и при изменении времени модификации файлов,
+// которое проверяется раз в interval; 0 отключает проверку файлов. Возвращается при отмене ctx
+func (rl *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			rl.reload("SIGHUP")
+		case <-tick:
+			modTime, err := rl.lastModified()
+			if err != nil {
+				slog.Error("failed to check tls certificate", "error", err)
+				continue
+			}
+
+			rl.mu.RLock()
+			changed := modTime.After(rl.modTime)
+			rl.mu.RUnlock()
+
+			if changed {
+				rl.reload("file change")
+			}
+		}
+	}
+}
+
+func (rl *Reloader) reload(reason string) {
+	if err := rl.Reload(); err != nil {
+		slog.Error("failed to reload tls certificate, keeping the previous one", "reason", reason, "error", err)
+		return
+	}
+	slog.Info("reloaded tls certificate", "file", rl.certFile, "reason", reason)
+}
+
+// lastModified возвращает наиболее позднее время модификации файлов сертификата и ключа
+func (rl *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{rl.certFile, rl.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s, error: %v", file, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewConfig строит tls.Config сервера по config.Web и возвращает его вместе с Reloader сертификата,
+// перечитывание которого запускает вызывающий через Watch. Возвращает nil, если TLS не настроен.
+// Клиентский сертификат запрашивается, но не обязателен: его наличие проверяет RequireClientCert
+// только для путей администрирования
+func NewConfig(c *config.Web) (*tls.Config, *Reloader, error) {
+	if c.TLSCertFile == "" && c.TLSKeyFile == "" {
+		if c.TLSClientCAFile != "" {
+			return nil, nil, fmt.Errorf("tls client ca is set, but tls certificate and key are not")
+		}
+		return nil, nil, nil
+	}
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return nil, nil, fmt.Errorf("both tls certificate and key must be set")
+	}
+
+	minVersion, ok := minVersions[c.TLSMinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported tls min version: %s", c.TLSMinVersion)
+	}
+
+	rl, err := NewReloader(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: rl.GetCertificate,
+	}
+
+	if c.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(c.TLSClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read tls client ca %s, error: %v", c.TLSClientCAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in tls client ca %s", c.TLSClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, rl, nil
+}
+
+// RequireClientCert пропускает запросы к путям AdminPaths только с проверенным клиентским сертификатом.
+// Если TLSClientCAFile не задан, проверка отключена
+func RequireClientCert(c *config.Web, next http.Handler) http.Handler {
+	if c.TLSClientCAFile == "" || len(c.AdminPaths) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(c.AdminPaths, r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientCertRequired сообщает, что запросы к пути path пропускаются только с клиентским сертификатом
+func ClientCertRequired(c *config.Web, path string) bool {
+	return c.TLSClientCAFile != "" && isAdminPath(c.AdminPaths, path)
+}
+
+func isAdminPath(adminPaths []string, path string) bool {
+	for _, prefix := range adminPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mclyashko/everlock/internal/config"
+)
+
+// статические файлы не содержат секретов и могут кешироваться
+const staticPathPrefix = "/static/"
+
+// добавляет к ответам заголовки безопасности: CSP, запрет встраивания во фреймы, политику Referer
+// и HSTS при TLS. Ответы обработчиков, кроме статических файлов, несут части ключа, токены или открытый текст,
+// поэтому запрещается их кеширование
+func securityHeaders(c *config.Web, next http.Handler) http.Handler {
+	hsts := ""
+	if c.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int64(c.HSTSMaxAge.Seconds()))
+		if c.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+
+		if c.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", c.ContentSecurityPolicy)
+		}
+		if c.FrameOptions != "" {
+			h.Set("X-Frame-Options", c.FrameOptions)
+		}
+		if c.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", c.ReferrerPolicy)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+
+		// SecureCookies означает, что TLS завершается на прокси
+		if hsts != "" && (r.TLS != nil || c.SecureCookies) {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
+		if !strings.HasPrefix(r.URL.Path, staticPathPrefix) {
+			h.Set("Cache-Control", "no-store")
+			h.Set("Pragma", "no-cache")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mclyashko/everlock/internal/metrics"
+	"github.com/mclyashko/everlock/internal/tracing"
+)
+
+// учитывает запросы к пути route роутера в метриках и начинает для них серверный спан
+func instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, span := tracing.StartRequest(r, route)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		tracing.EndRequest(span, sw.status)
+		metrics.ObserveRequest(route, r.Method, sw.status, time.Since(start))
+	})
+}
+
+// запоминает код ответа обработчика
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap дает http.ResponseController доступ к исходному ResponseWriter
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -1,24 +1,133 @@
 package web
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/mclyashko/everlock/internal/blob"
 	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/crypt"
+	"github.com/mclyashko/everlock/internal/csrf"
+	"github.com/mclyashko/everlock/internal/logging"
 	"github.com/mclyashko/everlock/internal/logic"
+	"github.com/mclyashko/everlock/internal/metrics"
+	"github.com/mclyashko/everlock/internal/notify"
+	"github.com/mclyashko/everlock/internal/pow"
+	"github.com/mclyashko/everlock/internal/ratelimit"
+	"github.com/mclyashko/everlock/internal/static"
+	"github.com/mclyashko/everlock/internal/template"
+	"github.com/mclyashko/everlock/internal/tlsconf"
 )
 
-// настраивает HTTP роутер, задавая пути и их обработчики
-func ConfigureRouter(_ *config.App, p *pgxpool.Pool) {
-	http.HandleFunc("/", logic.MainPageHandler)
-	http.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
-		logic.SubmitMessageHandler(p, w, r)
-	})
-	http.HandleFunc("/decrypt/", func(w http.ResponseWriter, r *http.Request) {
-		logic.DecryptMessageHandler(p, w, r)
-	})
-	http.HandleFunc("/add_key/", func(w http.ResponseWriter, r *http.Request) {
-		logic.AddKeyHandler(p, w, r)
-	})
+// настраивает HTTP роутер, задавая пути и их обработчики, и возвращает обработчик с защитой от CSRF,
+// заголовками безопасности, проверкой клиентского сертификата для путей администрирования
+// и идентификатором запроса в журнале. Фоновые задачи роутера останавливаются при отмене ctx
+func ConfigureRouter(ctx context.Context, c *config.App, p *pgxpool.Pool, s blob.Store, cs crypt.Suite, n notify.Notifier, ready *atomic.Bool) (http.Handler, error) {
+	logic.SetAuditSecret(c.Audit.Secret)
+
+	clientIP, err := ratelimit.NewClientIP(&c.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	// ввод частей ключа, вето и скачивание вложений ограничиваются по адресу клиента и по сообщению,
+	// чтобы нельзя было раз за разом заполнять сообщение мусором, подбирать коды вето или нагружать сервер
+	// расшифровкой вложений. У каждого действия свой лимит по сообщению, чтобы одно не расходовало попытки другого.
+	// Просмотр страницы расшифровки ограничивается только по адресу, иначе обновление страницы
+	// отнимало бы попытки у хранителей; повторные неудачные восстановления ключа ограничивает блокировка
+	perIP := ratelimit.Rule{
+		Limiter: ratelimit.NewLimiter(ctx, c.RateLimit.IPPerMinute, c.RateLimit.IPBurst),
+		Key:     clientIP.Key,
+	}
+	perMessage := func() ratelimit.Rule {
+		return ratelimit.Rule{
+			Limiter: ratelimit.NewLimiter(ctx, c.RateLimit.MessagePerMinute, c.RateLimit.MessageBurst),
+			Key:     ratelimit.MessageID,
+		}
+	}
+
+	pw, err := pow.NewIssuer(&c.Abuse)
+	if err != nil {
+		return nil, err
+	}
+
+	// квота создания сообщений с одного адреса
+	perIPCreate := ratelimit.Rule{
+		Limiter: ratelimit.NewLimiter(ctx, c.Abuse.CreatePerHour/60, c.Abuse.CreateBurst),
+		Key:     clientIP.Key,
+	}
+
+	templates, err := template.NewRegistry(&c.Templates)
+	if err != nil {
+		return nil, err
+	}
+	logic.SetTemplates(templates)
+
+	if err = metrics.Register(metrics.NewPoolCollector(p)); err != nil {
+		return nil, err
+	}
+
+	// каждый путь учитывается в метриках запросов и трассировке под своим шаблоном
+	handle := func(pattern string, h http.Handler) {
+		http.Handle(pattern, instrument(pattern, h))
+	}
+
+	handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logic.MainPageHandler(pw, w, r)
+	}))
+	handle("/static/", http.StripPrefix("/static/", http.FileServerFS(static.FS(c.Templates.StaticDir))))
+	handle("/healthz", http.HandlerFunc(logic.HealthHandler))
+	handle("/readyz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logic.ReadyHandler(p, ready, w, r)
+	}))
+	handle("/submit", ratelimit.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		logic.SubmitMessageHandler(p, s, cs, &c.Abuse, pw, w, r)
+	}, perIPCreate))
+	handle("/decrypt/", ratelimit.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		logic.DecryptMessageHandler(p, n, cs, &c.RateLimit, clientIP, w, r)
+	}, perIP))
+	handle("/add_key/", ratelimit.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		logic.AddKeyHandler(p, clientIP, w, r)
+	}, perIP, perMessage()))
+	handle("/veto/", ratelimit.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		logic.VetoHandler(p, n, w, r)
+	}, perIP, perMessage()))
+	handle("/attachment/", ratelimit.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		logic.AttachmentHandler(p, s, &c.RateLimit, clientIP, w, r)
+	}, perIP, perMessage()))
+
+	// журнал событий выгружается только под паролем или клиентским сертификатом
+	auditHandler := func(w http.ResponseWriter, r *http.Request) {
+		logic.AuditHandler(p, w, r)
+	}
+	switch {
+	case c.Audit.Password != "":
+		handle("/audit/", ratelimit.Middleware(basicAuth("audit", c.Audit.Username, c.Audit.Password, auditHandler), perIP))
+	case tlsconf.ClientCertRequired(&c.Web, "/audit/"):
+		handle("/audit/", ratelimit.Middleware(auditHandler, perIP))
+	default:
+		slog.Warn("audit log export is disabled, set AUDIT_PASSWORD or require a client certificate for /audit/ in ADMIN_PATHS")
+		handle("/audit/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Audit log export is disabled", http.StatusForbidden)
+		}))
+	}
+
+	handle("/invite", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logic.InviteHandler(p, w, r)
+	}))
+	handle("/invite/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logic.InviteHandler(p, w, r)
+	}))
+
+	// без отдельного адреса метрики отдаются основным сервером только под паролем
+	if c.Metrics.Addr == "" && c.Metrics.Password != "" {
+		http.Handle("/metrics", metrics.Handler(&c.Metrics))
+	}
+
+	handler := tlsconf.RequireClientCert(&c.Web, csrf.Protect(&c.Web, http.DefaultServeMux))
+	return logging.Middleware(securityHeaders(&c.Web, handler)), nil
 }
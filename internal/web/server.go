@@ -0,0 +1,114 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mclyashko/everlock/internal/config"
+	"github.com/mclyashko/everlock/internal/metrics"
+)
+
+// создает HTTP сервер с таймаутами из конфигурации, чтобы медленные клиенты не удерживали соединения
+func NewServer(c *config.Web, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              ":" + c.Port,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+// создает отдельный сервер метрик, если для них задан адрес, иначе возвращает nil
+func NewMetricsServer(c *config.App) *http.Server {
+	if c.Metrics.Addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(&c.Metrics))
+
+	return &http.Server{
+		Addr:              c.Metrics.Addr,
+		Handler:           mux,
+		ReadTimeout:       c.Web.ReadTimeout,
+		ReadHeaderTimeout: c.Web.ReadHeaderTimeout,
+		WriteTimeout:      c.Web.WriteTimeout,
+		IdleTimeout:       c.Web.IdleTimeout,
+		MaxHeaderBytes:    c.Web.MaxHeaderBytes,
+	}
+}
+
+// запускает серверы и ждет SIGINT или SIGTERM. После сигнала сбрасывает ready, в течение c.ShutdownDelay
+// продолжает обслуживать запросы, затем перестает принимать соединения и дожидается завершения начатых
+// запросов, но не дольше c.ShutdownTimeout, после чего отменяет контексты незавершенных запросов. Если один из серверов падает, останавливаются и остальные
+func ListenAndServe(c *config.Web, ready *atomic.Bool, servers ...*http.Server) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	// контекст всех запросов отменяется, если они не завершились за c.ShutdownTimeout,
+	// чтобы прервать ожидающие обращения к БД
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	for _, server := range servers {
+		server.BaseContext = func(net.Listener) context.Context { return requestCtx }
+	}
+
+	ready.Store(true)
+
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			if server.TLSConfig != nil {
+				serveErr <- server.ListenAndServeTLS("", "")
+			} else {
+				serveErr <- server.ListenAndServe()
+			}
+		}()
+	}
+
+	var failure error
+	received := 0
+	select {
+	case failure = <-serveErr:
+		received++
+		slog.Error("server failed, shutting down", "error", failure)
+	case sig := <-stop:
+		slog.Info("received signal, shutting down", "signal", sig.String())
+		ready.Store(false)
+		time.Sleep(c.ShutdownDelay)
+	}
+	ready.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && failure == nil {
+			failure = err
+		}
+	}
+	cancelRequests()
+
+	// после Shutdown остальные серверы возвращают http.ErrServerClosed
+	for ; received < len(servers); received++ {
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) && failure == nil {
+			failure = err
+		}
+	}
+
+	return failure
+}